@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errAssertionsFailed is returned by StartCmdWithConfig when a run breaks one of its assertions
+var errAssertionsFailed = errors.New("assertions failed")
+
+// assertionOperators are checked longest first so "<=" isn't read as "<"
+var assertionOperators = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+// Assertion is a parsed pass/fail threshold such as "p99 < 20ms" or "lookup.error_rate < 0.1%".
+// The metric can be scoped to a query name with a "<query>." prefix, or to the pool with "pool.".
+type Assertion struct {
+	Expression string
+	Query      string
+	Metric     string
+	Operator   string
+	Threshold  float64
+}
+
+// AssertionResult is the outcome of one assertion against the run summary
+type AssertionResult struct {
+	Expression string  `json:"expression"`
+	Actual     float64 `json:"actual"`
+	Passed     bool    `json:"passed"`
+}
+
+// parseAssertion parses "<metric> <operator> <value>".
+// Values can be durations (20ms), percentages (0.1%) or plain numbers.
+// Durations are compared in milliseconds and percentages as fractions.
+func parseAssertion(expression string) (*Assertion, error) {
+	for _, op := range assertionOperators {
+		left, right, found := strings.Cut(expression, op)
+		if !found {
+			continue
+		}
+
+		a := &Assertion{Expression: expression, Operator: op}
+		a.Query, a.Metric = splitAssertionMetric(left)
+		if _, ok := assertionMetrics[a.Metric]; !ok && a.Query != "pool" {
+			return nil, fmt.Errorf("assertion %q: unknown metric %q, expected one of %s or pool.<metric> with one of %s",
+				expression, a.Metric, metricNames(assertionMetrics), metricNames(poolAssertionMetrics))
+		}
+		if _, ok := poolAssertionMetrics[a.Metric]; !ok && a.Query == "pool" {
+			return nil, fmt.Errorf("assertion %q: unknown pool metric %q, expected one of %s", expression, a.Metric, metricNames(poolAssertionMetrics))
+		}
+
+		threshold, err := parseThreshold(strings.TrimSpace(right))
+		if err != nil {
+			return nil, fmt.Errorf("assertion %q: %w", expression, err)
+		}
+		a.Threshold = threshold
+		return a, nil
+	}
+	return nil, fmt.Errorf("assertion %q: missing comparison operator", expression)
+}
+
+// splitAssertionMetric splits the left side of an assertion into its query scope and metric.
+// Words can be separated by spaces too, so "pool wait_count" and "connect failures" name pool metrics
+// like "pool.wait_count" and "pool.connect_failures". Pool metrics need no "pool." prefix.
+func splitAssertionMetric(left string) (string, string) {
+	words := strings.Fields(left)
+	if len(words) > 1 && words[0] == "pool" {
+		return "pool", strings.Join(words[1:], "_")
+	}
+	query, metric := "", strings.Join(words, "_")
+	if i := strings.LastIndex(metric, "."); i >= 0 {
+		query, metric = metric[:i], metric[i+1:]
+	}
+	if _, ok := poolAssertionMetrics[metric]; ok && query == "" {
+		return "pool", metric
+	}
+	return query, metric
+}
+
+// metricNames lists the metrics an assertion can name, in order
+func metricNames[V any](metrics map[string]V) string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// parseThreshold converts a duration, percentage or number to a float
+func parseThreshold(value string) (float64, error) {
+	if strings.HasSuffix(value, "%") {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid percentage %q", value)
+		}
+		return f / 100, nil
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid threshold %q", value)
+	}
+	return float64(d) / float64(time.Millisecond), nil
+}
+
+// assertionMetrics reads a metric from a latency summary line
+var assertionMetrics = map[string]func(s *LatencySummary) float64{
+	"count":      func(s *LatencySummary) float64 { return float64(s.Count) },
+	"errors":     func(s *LatencySummary) float64 { return float64(s.Errors) },
+	"error_rate": func(s *LatencySummary) float64 { return errorRate(s) },
+	"throughput": func(s *LatencySummary) float64 { return s.Throughput },
+	"min":        func(s *LatencySummary) float64 { return s.Min },
+	"mean":       func(s *LatencySummary) float64 { return s.Mean },
+	"p50":        func(s *LatencySummary) float64 { return s.P50 },
+	"p90":        func(s *LatencySummary) float64 { return s.P90 },
+	"p99":        func(s *LatencySummary) float64 { return s.P99 },
+	"p999":       func(s *LatencySummary) float64 { return s.P999 },
+	"max":        func(s *LatencySummary) float64 { return s.Max },
+}
+
+// poolAssertionMetrics reads a metric from the run summary for "pool." assertions
+var poolAssertionMetrics = map[string]func(s *Summary) float64{
+	"wait_count":       func(s *Summary) float64 { return float64(s.Pool.WaitCount) },
+	"wait_duration":    func(s *Summary) float64 { return s.Pool.WaitDuration },
+	"mean_wait":        func(s *Summary) float64 { return s.Pool.MeanWait },
+	"connect_failures": func(s *Summary) float64 { return float64(s.Pool.ConnectFailures) },
+}
+
+func errorRate(s *LatencySummary) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Count)
+}
+
+// Evaluate checks the assertion against the summary.
+// Assertions on a query that never ran fail with an actual value of zero.
+func (a *Assertion) Evaluate(summary *Summary) AssertionResult {
+	var actual float64
+	switch {
+	case a.Query == "pool":
+		actual = poolAssertionMetrics[a.Metric](summary)
+	case a.Query == "":
+		actual = assertionMetrics[a.Metric](&summary.Total)
+	default:
+		found := false
+		for i := range summary.ByQuery {
+			if summary.ByQuery[i].Name == a.Query {
+				actual = assertionMetrics[a.Metric](&summary.ByQuery[i])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return AssertionResult{Expression: a.Expression}
+		}
+	}
+
+	var passed bool
+	switch a.Operator {
+	case "<":
+		passed = actual < a.Threshold
+	case "<=":
+		passed = actual <= a.Threshold
+	case ">":
+		passed = actual > a.Threshold
+	case ">=":
+		passed = actual >= a.Threshold
+	case "==":
+		passed = actual == a.Threshold
+	case "!=":
+		passed = actual != a.Threshold
+	}
+	return AssertionResult{Expression: a.Expression, Actual: actual, Passed: passed}
+}
+
+// parseAssertions parses every configured assertion
+func parseAssertions(expressions []string) ([]*Assertion, error) {
+	var assertions []*Assertion
+	for _, expression := range expressions {
+		a, err := parseAssertion(expression)
+		if err != nil {
+			return nil, err
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, nil
+}
+
+// checkAssertions evaluates the assertions, stores the results in the summary
+// and returns errAssertionsFailed listing the ones that failed
+func checkAssertions(assertions []*Assertion, summary *Summary) error {
+	var failed []string
+	for _, a := range assertions {
+		result := a.Evaluate(summary)
+		summary.Assertions = append(summary.Assertions, result)
+		if !result.Passed {
+			failed = append(failed, fmt.Sprintf("%s (actual %g)", result.Expression, result.Actual))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%w: %s", errAssertionsFailed, strings.Join(failed, "; "))
+	}
+	return nil
+}
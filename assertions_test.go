@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		expression string
+		query      string
+		metric     string
+		operator   string
+		threshold  float64
+	}{
+		{"p99 < 20ms", "", "p99", "<", 20},
+		{"error_rate < 0.1%", "", "error_rate", "<", 0.001},
+		{"pool.wait_count == 0", "pool", "wait_count", "==", 0},
+		{"pool.connect_failures <= 0", "pool", "connect_failures", "<=", 0},
+		{"lookup.p999 >= 1.5s", "lookup", "p999", ">=", 1500},
+		{"throughput > 100", "", "throughput", ">", 100},
+		{"pool wait_count == 0", "pool", "wait_count", "==", 0},
+		{"connect failures == 0", "pool", "connect_failures", "==", 0},
+		{"lookup.error rate < 1%", "lookup", "error_rate", "<", 0.01},
+	}
+	for _, tt := range tests {
+		a, err := parseAssertion(tt.expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.expression, err)
+		}
+		if a.Query != tt.query || a.Metric != tt.metric || a.Operator != tt.operator || a.Threshold != tt.threshold {
+			t.Errorf("Unexpected parse of %q: %+v", tt.expression, a)
+		}
+	}
+
+	for _, bad := range []string{"p99 20ms", "p42 < 20ms", "pool.p99 < 1ms", "p99 < fast"} {
+		if _, err := parseAssertion(bad); err == nil {
+			t.Errorf("Expected error parsing %q", bad)
+		}
+	}
+
+	// Unknown metrics list the names that would have been accepted
+	if _, err := parseAssertion("pool.waits == 0"); err == nil || !strings.Contains(err.Error(), "wait_count") {
+		t.Errorf("Expected the pool metrics in the error, got %v", err)
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	summary := &Summary{
+		Total: LatencySummary{Name: "total", Count: 1000, Errors: 2, P99: 12.5},
+		ByQuery: []LatencySummary{
+			{Name: "lookup", Count: 1000, Errors: 2, P99: 12.5},
+		},
+		Pool: PoolSummary{WaitCount: 3},
+	}
+
+	assertions, err := parseAssertions([]string{"p99 < 20ms", "lookup.error_rate < 0.1%", "pool.wait_count == 0", "missing.count > 0"})
+	if err != nil {
+		t.Fatalf("Failed to parse assertions: %v", err)
+	}
+
+	err = checkAssertions(assertions, summary)
+	if !errors.Is(err, errAssertionsFailed) {
+		t.Fatalf("Expected assertions to fail, got %v", err)
+	}
+
+	expected := []bool{true, false, false, false}
+	for i, result := range summary.Assertions {
+		if result.Passed != expected[i] {
+			t.Errorf("Expected %q passed=%v, got %+v", result.Expression, expected[i], result)
+		}
+	}
+	if summary.Assertions[1].Actual != 0.002 {
+		t.Errorf("Expected error rate 0.002, got %v", summary.Assertions[1].Actual)
+	}
+	expectedErr := fmt.Sprintf("%v: lookup.error_rate < 0.1%% (actual 0.002); pool.wait_count == 0 (actual 3); missing.count > 0 (actual 0)", errAssertionsFailed)
+	if err.Error() != expectedErr {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+}
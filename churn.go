@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Connection modes of a scenario
+const (
+	connectionModePool  = "pool"
+	connectionModeChurn = "churn"
+)
+
+// checkConnectionMode rejects unknown connection modes and the modes a scenario's other settings can't run in
+func checkConnectionMode(sc *ScenarioConfig) error {
+	switch sc.ConnectionMode {
+	case "", connectionModePool:
+		switch {
+		case sc.TrackIdentity && sc.VerifySplit:
+			return fmt.Errorf("track_identity and verify_split can't be combined")
+		case sc.StatementMode == statementModePrepared && (sc.TrackIdentity || sc.VerifySplit || sc.Transaction != nil):
+			return fmt.Errorf("statement mode %q can't be combined with track_identity, verify_split or transactions", statementModePrepared)
+		case sc.Transaction != nil && (sc.TrackIdentity || sc.VerifySplit):
+			return fmt.Errorf("transactions can't be combined with track_identity or verify_split")
+		}
+		return nil
+	case connectionModeChurn:
+		if sc.TrackIdentity || sc.VerifySplit || sc.Transaction != nil || sc.StatementMode == statementModePrepared {
+			// Both look up the backend on the connection that ran the statement, which churn has already closed,
+			// a transaction needs one connection for all of its statements and prepared statements are reused on the next query
+			return fmt.Errorf("track_identity, verify_split, transactions and prepared statements need connection mode %q", connectionModePool)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown connection mode %q", sc.ConnectionMode)
+}
+
+// churnDB opens a new connection for every query and closes it afterwards,
+// recording the TCP connect, handshake/auth and query time separately
+type churnDB struct {
+	db           *sqlx.DB
+	target       string
+	scenario     string
+	interpolated bool
+}
+
+// newChurnDB creates a database handle for a target that never keeps idle connections
+func newChurnDB(target, dsn, scenario string) (*churnDB, error) {
+	connector, err := newTimedConnector(target, dsn)
+	if err != nil {
+		return nil, err
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	db.SetMaxIdleConns(0) // Close every connection as soon as it is released
+
+	interpolated := statementModeLabel(statementModePerQuery, dsn) == statementModeInterpolated
+	return &churnDB{db: db, target: target, scenario: scenario, interpolated: interpolated}, nil
+}
+
+// query dials a connection, runs the statement on it and closes it
+func (c *churnDB) query(query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+	timing := &dialTiming{}
+	ctx := withDialTiming(context.Background(), timing)
+
+	start := time.Now()
+	conn, err := c.db.Connx(ctx)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseConnect, err: err}
+	}
+	defer conn.Close()
+	connected := time.Since(start)
+	churnDuration.WithLabelValues(c.target, c.scenario, "tcp_connect").Observe((timing.dns + timing.tcp).Seconds())
+	churnDuration.WithLabelValues(c.target, c.scenario, "handshake").Observe((connected - timing.dns - timing.tcp).Seconds())
+
+	start = time.Now()
+	defer func() {
+		churnDuration.WithLabelValues(c.target, c.scenario, "query").Observe(time.Since(start).Seconds())
+	}()
+
+	return runStatement(ctx, paramRunner(conn, c.interpolated), query, values)
+}
+
+// Close closes the database handle
+func (c *churnDB) Close() {
+	c.db.Close()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestChurnDBConnectFailure(t *testing.T) {
+	resetMetrics()
+
+	// A listener that accepts connections and hangs up before the MySQL handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	churn, err := newChurnDB("primary", fmt.Sprintf("user:password@tcp(%s)/testdb", listener.Addr()), "churn")
+	if err != nil {
+		t.Fatalf("Failed to create churn database: %v", err)
+	}
+	defer churn.Close()
+
+	// The TCP connect succeeds but the handshake doesn't, so the query fails in the connect phase
+	_, _, err = churn.query("SELECT 1", nil)
+	if err == nil {
+		t.Fatalf("Expected query to fail without a MySQL server")
+	}
+	if class := classifyError(err); class.Phase != phaseConnect {
+		t.Errorf("Expected a connect phase error, got %s", class)
+	}
+	if count := testutil.CollectAndCount(churnDuration, "db_churn_duration_seconds"); count != 0 {
+		t.Errorf("Expected no churn timings for a failed connection, got %d", count)
+	}
+
+	// Bad DSNs are rejected up front
+	if _, err := newChurnDB("primary", "not a dsn", "churn"); err == nil {
+		t.Errorf("Expected error for an invalid DSN")
+	}
+}
+
+func TestCheckConnectionMode(t *testing.T) {
+	tests := []struct {
+		sc    ScenarioConfig
+		valid bool
+	}{
+		{ScenarioConfig{}, true},
+		{ScenarioConfig{ConnectionMode: connectionModeChurn, StatementMode: statementModePerQuery}, true},
+		{ScenarioConfig{ConnectionMode: "pooled"}, false},
+		{ScenarioConfig{TrackIdentity: true, VerifySplit: true}, false},
+		{ScenarioConfig{StatementMode: statementModePrepared, TrackIdentity: true}, false},
+		{ScenarioConfig{Transaction: &TransactionConfig{}, VerifySplit: true}, false},
+		{ScenarioConfig{ConnectionMode: connectionModeChurn, Transaction: &TransactionConfig{}}, false},
+	}
+	for _, test := range tests {
+		if err := checkConnectionMode(&test.sc); (err == nil) != test.valid {
+			t.Errorf("Unexpected result for %+v: %v", test.sc, err)
+		}
+	}
+
+	// An invalid mode fails the run before connecting, instead of every worker quietly exiting
+	cfg := &Config{Database: DatabaseConfig{DSN: "user:password@tcp(127.0.0.1:3306)/testdb", ConcurrentWorkers: 1, ConnectionMode: "pooled"}}
+	err := StartCmdWithConfig(cfg, func(*DatabaseConfig) (*DBWrapper, error) {
+		t.Errorf("Expected no connection with an invalid connection mode")
+		return nil, fmt.Errorf("not connecting")
+	})
+	if err == nil || !strings.Contains(err.Error(), `unknown connection mode "pooled"`) {
+		t.Errorf("Expected the run to fail on the connection mode, got %v", err)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -21,34 +24,226 @@ func (lg *logWriter) Write(p []byte) (int, error) {
 }
 
 // StartCmdWithConfig allows for starting with dependency injection (for testing)
-func StartCmdWithConfig(cfg *Config, dbInitFunc func(cfg *Config) (*DBWrapper, error)) error {
+func StartCmdWithConfig(cfg *Config, dbInitFunc func(target *DatabaseConfig) (*DBWrapper, error)) error {
 
 	// Customize log output format
 	log.SetFlags(0) // Disable default timestamp
 	log.SetOutput(new(logWriter))
 
-	// Initialize the database connection using the injected function
-	dbWrapper, err := dbInitFunc(cfg)
+	// Check the targets and scenarios before connecting
+	targetConfigs := cfg.TargetConfigs()
+	scenarios := cfg.ScenarioConfigs()
+	if err := checkTargets(targetConfigs, scenarios); err != nil {
+		return err
+	}
+	if cfg.Heartbeat != nil {
+		if err := cfg.Heartbeat.check(targetConfigs); err != nil {
+			return err
+		}
+	}
+	if cfg.Consistency != nil {
+		if err := cfg.Consistency.check(targetConfigs); err != nil {
+			return err
+		}
+	}
+	statementModes := false
+	for _, sc := range scenarios {
+		if err := checkStatementMode(sc.StatementMode); err != nil {
+			return fmt.Errorf("scenario %s: %w", sc.label(), err)
+		}
+		if err := checkConnectionMode(&sc); err != nil {
+			return fmt.Errorf("scenario %s: %w", sc.label(), err)
+		}
+		statementModes = statementModes || sc.StatementMode != ""
+		if err := checkSeedSource(&sc); err != nil {
+			return fmt.Errorf("scenario %s: %w", sc.label(), err)
+		}
+		if sc.LoadProfile != nil {
+			if err := sc.LoadProfile.validate(); err != nil {
+				return fmt.Errorf("scenario %s load profile: %w", sc.label(), err)
+			}
+		}
+		if sc.SeedDistribution != nil {
+			if err := sc.SeedDistribution.validate(); err != nil {
+				return fmt.Errorf("scenario %s: %w", sc.label(), err)
+			}
+		}
+		if sc.Transaction != nil {
+			if err := sc.Transaction.validate(); err != nil {
+				return fmt.Errorf("scenario %s: %w", sc.label(), err)
+			}
+		}
+		// Statements and their value generators are checked once instead of failing every worker
+		if sc.ConcurrentWorkers > 0 || sc.TargetRate > 0 || sc.LoadProfile != nil {
+			if _, err := buildWorkload(&sc); err != nil {
+				return fmt.Errorf("scenario %s: %w", sc.label(), err)
+			}
+		}
+	}
+
+	assertions, err := parseAssertions(cfg.Assertions)
 	if err != nil {
 		return err
 	}
-	defer dbWrapper.Close()
-	log.Println("Connected to the database successfully")
+
+	// Initialize the connection pool of every target using the injected function
+	targets := make([]*Target, 0, len(targetConfigs))
+	for i := range targetConfigs {
+		tc := &targetConfigs[i]
+		dbWrapper, err := dbInitFunc(tc)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", tc.Name, err)
+		}
+		defer dbWrapper.Close()
+		log.Printf("Connected to target %s successfully", tc.Name)
+
+		// Report each pool once, no matter how many workers share it
+		poolCollector.Add(tc.Name, dbWrapper.DB)
+		defer poolCollector.Remove(tc.Name)
+		targets = append(targets, &Target{Name: tc.Name, Config: tc, DB: dbWrapper.DB})
+	}
 
 	// Start prometheus server
 	go startMetricsServer(cfg.MetricsPort, "/metrics")
 
-	// Start multiple workers based on the configuration
-	for i := 0; i < cfg.Database.ConcurrentWorkers; i++ {
-		go RunQueryWorkers(cfg, dbWrapper.DB, i)
-		go collectDBPoolMetrics(dbWrapper.DB, fmt.Sprintf("%d", i), cfg.MetricsInterval)
+	// Cancelled on shutdown to stop dispatching new queries
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Collect results for the end-of-run summary
+	runStats = NewRunStats(cfg.MaxQueries)
+	identities = newIdentityTracker()
+	splitChecks = newSplitRecorder()
+	transactions = newTransactionRecorder()
+	preparedStmts = newStatementCache()
+	seedSets = newSeedRegistry(ctx)
+	checksums = newChecksumRecorder()
+	defer preparedStmts.Close()
+	timeline = nil
+	if cfg.Failover != nil {
+		var eventLog *os.File
+		if cfg.Failover.EventLog != "" {
+			eventLog, err = os.OpenFile(cfg.Failover.EventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+			if err != nil {
+				return fmt.Errorf("failover event log: %w", err)
+			}
+			defer eventLog.Close()
+		}
+		timeline = newFailoverTimeline(*cfg.Failover, eventLog)
+	}
+	runStart := time.Now()
+	poolStart := poolStats(targets)
+
+	// Start the workers of every scenario against each of its targets
+	var wg sync.WaitGroup
+	run := func(f func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	workerID := 0
+	for s := range scenarios {
+		sc := &scenarios[s]
+		for _, target := range scenarioTargets(sc, targets) {
+			if sc.Name != "" {
+				log.Printf("Starting scenario %s on target %s with %d workers", sc.Name, target.Name, sc.ConcurrentWorkers)
+			}
+			switch {
+			case sc.TargetRate > 0 || (sc.LoadProfile != nil && sc.LoadProfile.isRate()):
+				// Open-loop scenarios use one dispatcher instead of a fixed set of workers
+				id := workerID
+				run(func() { RunOpenLoop(ctx, cfg, sc, target, id) })
+				workerID++
+			case sc.LoadProfile != nil:
+				// Reserve worker IDs for the busiest stage of the profile
+				id := workerID
+				run(func() { RunWorkerProfile(ctx, cfg, sc, target, id) })
+				workerID += int(math.Ceil(sc.LoadProfile.maxLevel()))
+			default:
+				for i := 0; i < sc.ConcurrentWorkers; i++ {
+					id := workerID
+					run(func() { RunQueryWorkers(ctx, cfg, sc, target, id) })
+					workerID++
+				}
+			}
+		}
+	}
+	var idleSummaries []*IdleSummary
+	if cfg.IdleTest != nil {
+		idleSummaries = make([]*IdleSummary, len(targets))
+		for i, target := range targets {
+			run(func() { idleSummaries[i] = RunIdleTest(ctx, target, cfg.IdleTest) })
+		}
+	}
+	var replication []ReplicaLagSummary
+	if cfg.Heartbeat != nil {
+		run(func() { replication = RunHeartbeat(ctx, cfg.Heartbeat, targets) })
+	}
+	if timeline != nil {
+		run(func() { RunFailoverTimeline(ctx, timeline, targets) })
+	}
+	var consistency *ConsistencySummary
+	if cfg.Consistency != nil {
+		run(func() { consistency = RunConsistency(ctx, cfg.Consistency, targets, uint64(cfg.RandomSeed)) })
+	}
+	var stmtStatus []StatementStatusSummary
+	if statementModes {
+		run(func() { stmtStatus = RunStatementStatus(ctx, targets) })
+	}
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	// Stop after the configured duration, if any
+	var deadline <-chan time.Time
+	if cfg.Duration > 0 {
+		deadline = time.After(cfg.Duration)
 	}
 
 	// Set up signal handling to allow graceful shutdown
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+	defer signal.Stop(sigs)
 
-	log.Println("Shutting down gracefully")
-	return nil
+	select {
+	case <-sigs:
+		log.Println("Shutting down gracefully")
+	case <-deadline:
+		log.Printf("Run duration of %v reached", cfg.Duration)
+	case <-runStats.LimitReached():
+		log.Printf("Reached the limit of %d queries", cfg.MaxQueries)
+	case <-finished:
+		log.Println("All scenarios finished")
+	}
+
+	// Stop the workers and wait for their last queries before reporting
+	cancel()
+	<-finished
+	// Seed loads still use the pools closed on return
+	seedSets.wait()
+
+	summary := runStats.Summary(time.Since(runStart), poolSummary(poolStart, poolStats(targets)))
+	for _, idle := range idleSummaries {
+		if idle != nil {
+			summary.IdleTest = append(summary.IdleTest, idle)
+		}
+	}
+	summary.Identity = identities.Summary()
+	summary.Replication = replication
+	summary.Failover = timeline.Summary()
+	summary.Split = splitChecks.Summary()
+	summary.Consistency = consistency
+	summary.Transactions = transactions.Summary()
+	if summary.Statements != nil {
+		summary.Statements.Servers = stmtStatus
+	}
+	assertionErr := checkAssertions(assertions, summary)
+	if err := WriteSummary(os.Stdout, summary, cfg.SummaryFormat); err != nil {
+		return err
+	}
+	return assertionErr
 }
@@ -7,18 +7,10 @@ import (
 )
 
 func TestStartCmdIntegration(t *testing.T) {
-	// Set up a channel to stop the test after a specified period
-	stopChan := make(chan struct{})
-
-	// Start a goroutine that will close the stop channel after 1 second
-	go func() {
-		time.Sleep(1 * time.Second)
-		close(stopChan)
-	}()
-
 	cfg := &Config{
 		Debug:       true,
 		MetricsPort: "2112",
+		Duration:    1 * time.Second, // Bound the run so StartCmdWithConfig returns
 		Database: DatabaseConfig{
 			DSN:                fmt.Sprintf("root:password@tcp(%s:%s)/testdb?parseTime=true", MysqlHost, MysqlPort),
 			MaxOpenConns:       5,
@@ -27,7 +19,7 @@ func TestStartCmdIntegration(t *testing.T) {
 			ConnMaxLifetime:    30 * time.Second,
 			ConnIdleTimeout:    15 * time.Second,
 			TestQuery:          "SELECT 1",
-			QueryInterval:      1 * time.Second,
+			QueryInterval:      100 * time.Millisecond,
 			ConcurrentWorkers:  1,
 		},
 	}
@@ -42,15 +34,14 @@ func TestStartCmdIntegration(t *testing.T) {
 		t.Fatalf("StartCmdWithConfig did not fail with bad input")
 	}
 
-	// Start `StartCmdWithConfig` using the shared DB connection in a separate goroutine
-	go func() {
-		if err := StartCmdWithConfig(cfg, InitializeDBWrapper); err != nil {
-			t.Fatalf("StartCmdWithConfig failed: %v", err)
-		}
-	}()
-
-	// Wait for the stopChan to be closed, indicating the end of the test duration
-	<-stopChan
+	// Run StartCmdWithConfig until the configured duration is reached
+	start := time.Now()
+	if err := StartCmdWithConfig(cfg, InitializeDBWrapper); err != nil {
+		t.Fatalf("StartCmdWithConfig failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < cfg.Duration {
+		t.Errorf("Expected run to last at least %v, got %v", cfg.Duration, elapsed)
+	}
 
 	t.Logf("Integration test for StartCmdWithConfig completed successfully")
 }
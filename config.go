@@ -2,36 +2,209 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 )
 
 type DatabaseConfig struct {
-	DSN                string        `yaml:"dsn"`
-	MaxOpenConns       int           `yaml:"max_open_conns"`
-	MaxIdleConns       int           `yaml:"max_idle_conns"`
-	ConnMaxLifetime    time.Duration `yaml:"conn_max_lifetime"`
-	ConnIdleTimeout    time.Duration `yaml:"conn_idle_timeout"`
-	NumIdleConnections int           `yaml:"idle_connections"`
-	TestQuery          string        `yaml:"test_query"`
-	QueryFile          string        `yaml:"query_file"`
-	SeedQuery          string        `yaml:"seed_query"`
-	QueryTemplate      string        `yaml:"query_template"`
-	QueryInterval      time.Duration `yaml:"query_interval"`
-	ConcurrentWorkers  int           `yaml:"concurrent_workers"`
-	QueriesPerWorker   int           `yaml:"queries_per_worker"`
-	Queries            []string      `yaml:"queries"`
+	Name                string                  `yaml:"name"` // Target name used in metrics, defaults to the DSN address
+	DSN                 string                  `yaml:"dsn"`
+	MaxOpenConns        int                     `yaml:"max_open_conns"`
+	MaxIdleConns        int                     `yaml:"max_idle_conns"`
+	ConnMaxLifetime     time.Duration           `yaml:"conn_max_lifetime"`
+	ConnIdleTimeout     time.Duration           `yaml:"conn_idle_timeout"`
+	NumIdleConnections  int                     `yaml:"idle_connections"`
+	TestQuery           string                  `yaml:"test_query"`
+	QueryFile           string                  `yaml:"query_file"`
+	SeedQuery           string                  `yaml:"seed_query"`
+	SeedFile            string                  `yaml:"seed_file"` // CSV with a header line or JSONL seed rows, instead of the seed query
+	QueryTemplate       string                  `yaml:"query_template"`
+	QueryTemplateWeight int                     `yaml:"query_template_weight"`
+	QueryInterval       time.Duration           `yaml:"query_interval"`
+	ConcurrentWorkers   int                     `yaml:"concurrent_workers"`
+	QueriesPerWorker    int                     `yaml:"queries_per_worker"`
+	Queries             []WeightedQuery         `yaml:"queries"`
+	TargetRate          float64                 `yaml:"target_rate"`   // Open-loop queries per second, 0 for closed loop
+	MaxInFlight         int                     `yaml:"max_in_flight"` // Safety limit of open-loop queries in flight, defaults to 10000
+	LoadProfile         *LoadProfileConfig      `yaml:"load_profile"`
+	ConnectionMode      string                  `yaml:"connection_mode"` // "pool" or "churn" for a new connection per query
+	TrackIdentity       bool                    `yaml:"track_identity"`  // Record which backend served every query
+	VerifySplit         bool                    `yaml:"verify_split"`    // Check that reads land on readers and writes on the writer
+	Transaction         *TransactionConfig      `yaml:"transaction"`     // Run multi-statement transactions instead of single statements
+	StatementMode       string                  `yaml:"statement_mode"`  // "per_query" or "prepared" to reuse statements prepared once per connection
+	SeedDistribution    *SeedDistributionConfig `yaml:"seed_distribution"`
+	SeedRefresh         time.Duration           `yaml:"seed_refresh"`   // Reload the seed values this often, 0 to load them once
+	SeedPageSize        int                     `yaml:"seed_page_size"` // Page through the seed query by seed_key, 0 to run it once
+	SeedKey             string                  `yaml:"seed_key"`       // Keyset pagination column, defaults to id
+	SeedStart           interface{}             `yaml:"seed_start"`     // Key the first page starts after, required with a page size
+	SeedMaxRows         int                     `yaml:"seed_max_rows"`  // Stop paging after this many rows, defaults to 1000000
+}
+
+// poolName returns the configured name, or the address from the DSN
+func (d *DatabaseConfig) poolName() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	if dsn, err := mysql.ParseDSN(d.DSN); err == nil && dsn.Addr != "" {
+		return dsn.Addr
+	}
+	return "default"
+}
+
+// ScenarioConfig describes one named workload that runs alongside the others
+type ScenarioConfig struct {
+	Name                string                  `yaml:"name"`
+	SeedQuery           string                  `yaml:"seed_query"`
+	SeedFile            string                  `yaml:"seed_file"` // CSV with a header line or JSONL seed rows, instead of the seed query
+	QueryTemplate       string                  `yaml:"query_template"`
+	QueryTemplateWeight int                     `yaml:"query_template_weight"`
+	QueryInterval       time.Duration           `yaml:"query_interval"`
+	ConcurrentWorkers   int                     `yaml:"concurrent_workers"`
+	QueriesPerWorker    int                     `yaml:"queries_per_worker"`
+	Queries             []WeightedQuery         `yaml:"queries"`
+	QueryFile           string                  `yaml:"query_file"`
+	TestQuery           string                  `yaml:"test_query"`
+	TargetRate          float64                 `yaml:"target_rate"`   // Open-loop queries per second, 0 for closed loop
+	MaxInFlight         int                     `yaml:"max_in_flight"` // Safety limit of open-loop queries in flight, defaults to 10000
+	LoadProfile         *LoadProfileConfig      `yaml:"load_profile"`
+	ConnectionMode      string                  `yaml:"connection_mode"` // "pool" or "churn" for a new connection per query
+	TrackIdentity       bool                    `yaml:"track_identity"`  // Record which backend served every query
+	VerifySplit         bool                    `yaml:"verify_split"`    // Check that reads land on readers and writes on the writer
+	Transaction         *TransactionConfig      `yaml:"transaction"`     // Run multi-statement transactions instead of single statements
+	StatementMode       string                  `yaml:"statement_mode"`  // "per_query" or "prepared" to reuse statements prepared once per connection
+	SeedDistribution    *SeedDistributionConfig `yaml:"seed_distribution"`
+	SeedRefresh         time.Duration           `yaml:"seed_refresh"`   // Reload the seed values this often, 0 to load them once
+	SeedPageSize        int                     `yaml:"seed_page_size"` // Page through the seed query by seed_key, 0 to run it once
+	SeedKey             string                  `yaml:"seed_key"`       // Keyset pagination column, defaults to id
+	SeedStart           interface{}             `yaml:"seed_start"`     // Key the first page starts after, required with a page size
+	SeedMaxRows         int                     `yaml:"seed_max_rows"`  // Stop paging after this many rows, defaults to 1000000
+	Targets             []string                `yaml:"targets"`        // Targets to run against, all of them when empty
+}
+
+// label returns the scenario name used in metrics
+func (sc *ScenarioConfig) label() string {
+	if sc.Name == "" {
+		return "default"
+	}
+	return sc.Name
 }
 
 type Config struct {
-	Debug           bool           `yaml:"debug"`
-	MetricsInterval time.Duration  `yaml:"metrics_interval"`
-	MetricsPort     string         `yaml:"metrics_port"`
-	Database        DatabaseConfig `yaml:"database"`
+	Debug           bool               `yaml:"debug"`
+	MetricsInterval time.Duration      `yaml:"metrics_interval"` // Deprecated and ignored, pool statistics are read at scrape time
+	MetricsPort     string             `yaml:"metrics_port"`
+	Duration        time.Duration      `yaml:"duration"`       // Stop the run after this long, 0 to run until interrupted
+	MaxQueries      int64              `yaml:"max_queries"`    // Stop the run after this many queries, 0 for no limit
+	SummaryFormat   string             `yaml:"summary_format"` // End-of-run summary as "text" or "json"
+	Assertions      []string           `yaml:"assertions"`     // Pass/fail thresholds checked at the end of the run
+	RandomSeed      int64              `yaml:"random_seed"`    // Makes the picked statements, seed rows and generated values repeatable, 0 for random
+	Database        DatabaseConfig     `yaml:"database"`
+	Targets         []DatabaseConfig   `yaml:"targets"` // Named databases with their own pools, replaces the database DSN when set
+	Scenarios       []ScenarioConfig   `yaml:"scenarios"`
+	IdleTest        *IdleTestConfig    `yaml:"idle_test"`   // Idle connection survival test, runs alongside the scenarios
+	Heartbeat       *HeartbeatConfig   `yaml:"heartbeat"`   // Replication lag probe between targets, runs alongside the scenarios
+	Failover        *FailoverConfig    `yaml:"failover"`    // Record the timeline of outages seen by the scenarios
+	Consistency     *ConsistencyConfig `yaml:"consistency"` // Compare the results of every target, runs alongside the scenarios
+}
+
+// TargetConfigs returns the configured targets, or the database section as the only target.
+// Every target is named, and unset pool settings fall back to the database section.
+func (c *Config) TargetConfigs() []DatabaseConfig {
+	if len(c.Targets) == 0 {
+		target := c.Database
+		target.Name = target.poolName()
+		return []DatabaseConfig{target}
+	}
+
+	targets := make([]DatabaseConfig, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		if t.MaxOpenConns == 0 {
+			t.MaxOpenConns = c.Database.MaxOpenConns
+		}
+		if t.MaxIdleConns == 0 {
+			t.MaxIdleConns = c.Database.MaxIdleConns
+		}
+		if t.ConnMaxLifetime == 0 {
+			t.ConnMaxLifetime = c.Database.ConnMaxLifetime
+		}
+		if t.ConnIdleTimeout == 0 {
+			t.ConnIdleTimeout = c.Database.ConnIdleTimeout
+		}
+		if t.NumIdleConnections == 0 {
+			t.NumIdleConnections = c.Database.NumIdleConnections
+		}
+		t.Name = t.poolName()
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// ScenarioConfigs returns the configured scenarios, or a single unnamed scenario
+// built from the database section when none are configured.
+// Unset scenario fields fall back to the database section.
+func (c *Config) ScenarioConfigs() []ScenarioConfig {
+	if len(c.Scenarios) == 0 {
+		return []ScenarioConfig{c.scenarioDefaults(ScenarioConfig{
+			SeedQuery:           c.Database.SeedQuery,
+			SeedFile:            c.Database.SeedFile,
+			QueryTemplate:       c.Database.QueryTemplate,
+			QueryTemplateWeight: c.Database.QueryTemplateWeight,
+			Queries:             c.Database.Queries,
+			QueryFile:           c.Database.QueryFile,
+			TargetRate:          c.Database.TargetRate,
+			MaxInFlight:         c.Database.MaxInFlight,
+			LoadProfile:         c.Database.LoadProfile,
+			ConnectionMode:      c.Database.ConnectionMode,
+			TrackIdentity:       c.Database.TrackIdentity,
+			VerifySplit:         c.Database.VerifySplit,
+			Transaction:         c.Database.Transaction,
+			StatementMode:       c.Database.StatementMode,
+			SeedDistribution:    c.Database.SeedDistribution,
+			SeedRefresh:         c.Database.SeedRefresh,
+			SeedPageSize:        c.Database.SeedPageSize,
+			SeedKey:             c.Database.SeedKey,
+			SeedStart:           c.Database.SeedStart,
+			SeedMaxRows:         c.Database.SeedMaxRows,
+		})}
+	}
+
+	scenarios := make([]ScenarioConfig, 0, len(c.Scenarios))
+	for i, sc := range c.Scenarios {
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("scenario_%d", i+1)
+		}
+		scenarios = append(scenarios, c.scenarioDefaults(sc))
+	}
+	return scenarios
+}
+
+// scenarioDefaults fills unset scenario fields from the database section
+func (c *Config) scenarioDefaults(sc ScenarioConfig) ScenarioConfig {
+	if sc.QueryInterval == 0 {
+		sc.QueryInterval = c.Database.QueryInterval
+	}
+	if sc.ConcurrentWorkers == 0 {
+		sc.ConcurrentWorkers = c.Database.ConcurrentWorkers
+	}
+	if sc.QueriesPerWorker == 0 {
+		sc.QueriesPerWorker = c.Database.QueriesPerWorker
+	}
+	if sc.QueriesPerWorker < 1 {
+		sc.QueriesPerWorker = 1
+	}
+	if sc.TestQuery == "" {
+		sc.TestQuery = c.Database.TestQuery
+	}
+	if sc.MaxInFlight == 0 {
+		sc.MaxInFlight = c.Database.MaxInFlight
+	}
+	return sc
 }
 
 // LoadConfig loads configuration from yaml and environment variables
@@ -57,6 +230,9 @@ func LoadConfig(configFile string) (*Config, error) {
 	viper.SetDefault("DEBUG", cfg.Debug)
 	viper.SetDefault("METRICS_PORT", cfg.MetricsPort)
 	viper.SetDefault("METRICS_INTERVAL", cfg.MetricsInterval)
+	viper.SetDefault("DURATION", cfg.Duration)
+	viper.SetDefault("MAX_QUERIES", cfg.MaxQueries)
+	viper.SetDefault("SUMMARY_FORMAT", cfg.SummaryFormat)
 	viper.SetDefault("DATABASE_DSN", cfg.Database.DSN)
 	viper.SetDefault("DATABASE_MAX_OPEN_CONNS", cfg.Database.MaxOpenConns)
 	viper.SetDefault("DATABASE_MAX_IDLE_CONNS", cfg.Database.MaxIdleConns)
@@ -65,10 +241,16 @@ func LoadConfig(configFile string) (*Config, error) {
 	viper.SetDefault("DATABASE_TEST_QUERY", cfg.Database.TestQuery)
 	viper.SetDefault("DATABASE_QUERY_FILE", cfg.Database.QueryFile)
 	viper.SetDefault("DATABASE_SEED_QUERY", cfg.Database.SeedQuery)
+	viper.SetDefault("DATABASE_SEED_FILE", cfg.Database.SeedFile)
 	viper.SetDefault("DATABASE_QUERY_TEMPLATE", cfg.Database.QueryTemplate)
 	viper.SetDefault("DATABASE_QUERY_INTERVAL", cfg.Database.QueryInterval)
 	viper.SetDefault("DATABASE_CONCURRENT_WORKERS", cfg.Database.ConcurrentWorkers)
+	viper.SetDefault("DATABASE_TARGET_RATE", cfg.Database.TargetRate)
 
+	cfg.MetricsInterval = viper.GetDuration("METRICS_INTERVAL")
+	cfg.Duration = viper.GetDuration("DURATION")
+	cfg.MaxQueries = viper.GetInt64("MAX_QUERIES")
+	cfg.SummaryFormat = viper.GetString("SUMMARY_FORMAT")
 	cfg.Database.DSN = viper.GetString("DATABASE_DSN")
 	cfg.Database.MaxOpenConns = viper.GetInt("DATABASE_MAX_OPEN_CONNS")
 	cfg.Database.MaxIdleConns = viper.GetInt("DATABASE_MAX_IDLE_CONNS")
@@ -77,10 +259,15 @@ func LoadConfig(configFile string) (*Config, error) {
 	cfg.Database.TestQuery = viper.GetString("DATABASE_TEST_QUERY")
 	cfg.Database.QueryFile = viper.GetString("DATABASE_QUERY_FILE")
 	cfg.Database.SeedQuery = viper.GetString("DATABASE_SEED_QUERY")
+	cfg.Database.SeedFile = viper.GetString("DATABASE_SEED_FILE")
 	cfg.Database.QueryTemplate = viper.GetString("DATABASE_QUERY_TEMPLATE")
 	cfg.Database.QueryInterval = viper.GetDuration("DATABASE_QUERY_INTERVAL")
 	cfg.Database.ConcurrentWorkers = viper.GetInt("DATABASE_CONCURRENT_WORKERS")
+	cfg.Database.TargetRate = viper.GetFloat64("DATABASE_TARGET_RATE")
 
+	if cfg.MetricsInterval != 0 {
+		log.Printf("metrics_interval is deprecated and ignored, pool statistics are now read on every scrape")
+	}
 	return &cfg, nil
 }
 
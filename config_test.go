@@ -47,6 +47,7 @@ func TestLoadConfig(t *testing.T) {
 
 	// Create a temporary YAML config file
 	yamlContent := `
+metrics_interval: 10s
 database:
   dsn: "user:password@tcp(localhost:3306)/dbname?parseTime=true&timeout=5s"
   max_open_conns: 50
@@ -116,4 +117,94 @@ database:
 	if cfg.Database.SeedQuery != "SELECT id FROM users ORDER BY RAND() LIMIT 5" {
 		t.Errorf("Unexpected SeedQuery value: %s", cfg.Database.SeedQuery)
 	}
+
+	// The deprecated metrics interval still loads, it is only ignored
+	if cfg.MetricsInterval != 10*time.Second {
+		t.Errorf("Unexpected MetricsInterval value: %v", cfg.MetricsInterval)
+	}
+}
+
+func TestScenarioConfigs(t *testing.T) {
+	cfg := &Config{
+		Database: DatabaseConfig{
+			TestQuery:         "SELECT 1",
+			SeedQuery:         "SELECT id FROM users LIMIT 5",
+			QueryTemplate:     "SELECT * FROM users WHERE id = ?",
+			QueryInterval:     1 * time.Second,
+			ConcurrentWorkers: 3,
+			QueriesPerWorker:  2,
+		},
+	}
+
+	// Without scenarios the database section is the only scenario
+	scenarios := cfg.ScenarioConfigs()
+	if len(scenarios) != 1 {
+		t.Fatalf("Expected 1 scenario, got %d", len(scenarios))
+	}
+	if scenarios[0].Name != "" || scenarios[0].QueryTemplate != cfg.Database.QueryTemplate || scenarios[0].ConcurrentWorkers != 3 {
+		t.Errorf("Unexpected default scenario: %+v", scenarios[0])
+	}
+
+	// Named scenarios inherit unset fields from the database section
+	cfg.Scenarios = []ScenarioConfig{
+		{Name: "lookups", QueryTemplate: "SELECT * FROM users WHERE id = ?", ConcurrentWorkers: 4},
+		{QueryTemplate: "SELECT * FROM users WHERE id > ? LIMIT 10", QueryInterval: 5 * time.Second},
+	}
+	scenarios = cfg.ScenarioConfigs()
+	if len(scenarios) != 2 {
+		t.Fatalf("Expected 2 scenarios, got %d", len(scenarios))
+	}
+	if scenarios[0].ConcurrentWorkers != 4 || scenarios[0].QueryInterval != 1*time.Second || scenarios[0].QueriesPerWorker != 2 {
+		t.Errorf("Unexpected lookups scenario: %+v", scenarios[0])
+	}
+	if scenarios[1].Name != "scenario_2" || scenarios[1].QueryInterval != 5*time.Second || scenarios[1].ConcurrentWorkers != 3 {
+		t.Errorf("Unexpected second scenario: %+v", scenarios[1])
+	}
+	if scenarios[1].SeedQuery != "" {
+		t.Errorf("Expected scenario seed query to stay unset, got %s", scenarios[1].SeedQuery)
+	}
+}
+
+func TestDatabasePoolName(t *testing.T) {
+	named := DatabaseConfig{Name: "primary", DSN: "user:password@tcp(db1:3306)/dbname"}
+	if name := named.poolName(); name != "primary" {
+		t.Errorf("Expected configured pool name, got %s", name)
+	}
+
+	unnamed := DatabaseConfig{DSN: "user:password@tcp(db1:3306)/dbname"}
+	if name := unnamed.poolName(); name != "db1:3306" {
+		t.Errorf("Expected pool name from DSN address, got %s", name)
+	}
+}
+
+func TestTargetConfigs(t *testing.T) {
+	cfg := &Config{
+		Database: DatabaseConfig{
+			DSN:             "user:password@tcp(db1:3306)/dbname",
+			MaxOpenConns:    20,
+			ConnMaxLifetime: time.Minute,
+		},
+	}
+
+	// Without targets the database section is the only target
+	targets := cfg.TargetConfigs()
+	if len(targets) != 1 || targets[0].Name != "db1:3306" || targets[0].MaxOpenConns != 20 {
+		t.Errorf("Unexpected default target: %+v", targets)
+	}
+
+	// Targets inherit unset pool settings from the database section
+	cfg.Targets = []DatabaseConfig{
+		{Name: "primary", DSN: "user:password@tcp(db1:3306)/dbname", MaxOpenConns: 5},
+		{DSN: "user:password@tcp(db2:3306)/dbname"},
+	}
+	targets = cfg.TargetConfigs()
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[0].Name != "primary" || targets[0].MaxOpenConns != 5 || targets[0].ConnMaxLifetime != time.Minute {
+		t.Errorf("Unexpected primary target: %+v", targets[0])
+	}
+	if targets[1].Name != "db2:3306" || targets[1].MaxOpenConns != 20 {
+		t.Errorf("Unexpected second target: %+v", targets[1])
+	}
 }
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// maxConsistencySamples limits how many divergences are kept for the summary
+const maxConsistencySamples = 20
+
+// Comparison results
+const (
+	comparisonMatch    = "match"
+	comparisonDiverged = "diverged"
+	comparisonError    = "error"
+)
+
+// ConsistencyConfig runs the same statements with the same values on every target at the same time
+// and compares their result sets with the reference target's, like a continuous pt-table-checksum
+type ConsistencyConfig struct {
+	Reference string          `yaml:"reference"`  // Target the others are compared with, defaults to the first target
+	Targets   []string        `yaml:"targets"`    // Targets compared with the reference, every other target when empty
+	Queries   []WeightedQuery `yaml:"queries"`    // Reads picked by weight, bound to params or to the seed values like a query template
+	SeedQuery string          `yaml:"seed_query"` // Runs on the reference target
+	SeedFile  string          `yaml:"seed_file"`  // CSV or JSONL seed rows, instead of the seed query
+	Interval  time.Duration   `yaml:"interval"`   // Between comparisons and their timeout, defaults to 1s
+}
+
+// reference returns the name of the reference target, first when none is set
+func (c *ConsistencyConfig) reference(first string) string {
+	if c.Reference != "" {
+		return c.Reference
+	}
+	return first
+}
+
+// check rejects comparisons that name unknown targets or could change data
+func (c *ConsistencyConfig) check(targets []DatabaseConfig) error {
+	if len(targets) < 2 {
+		return fmt.Errorf("consistency: needs at least two targets")
+	}
+	names := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		names[t.Name] = true
+	}
+	reference := c.reference(targets[0].Name)
+	if !names[reference] {
+		return fmt.Errorf("consistency: unknown reference target %q", reference)
+	}
+	for _, name := range c.Targets {
+		if !names[name] {
+			return fmt.Errorf("consistency: unknown target %q", name)
+		}
+		if name == reference {
+			return fmt.Errorf("consistency: target %q is the reference", name)
+		}
+	}
+
+	if len(c.Queries) == 0 {
+		return fmt.Errorf("consistency: no queries")
+	}
+	for i, q := range c.Queries {
+		// Every statement runs on every target, so a write would change them all
+		if q.kind() != kindRead {
+			return fmt.Errorf("consistency: query %d is not a read", i+1)
+		}
+	}
+	if c.SeedFile != "" {
+		if c.SeedQuery != "" {
+			return fmt.Errorf("consistency: seed_query and seed_file can't be combined")
+		}
+		if err := checkSeedFile(c.SeedFile); err != nil {
+			return fmt.Errorf("consistency: %w", err)
+		}
+	}
+	return nil
+}
+
+// ConsistencyDiff is one comparison where a target's result differed from the reference's
+type ConsistencyDiff struct {
+	Time              time.Time `json:"time"`
+	Target            string    `json:"target"`
+	Query             string    `json:"query"`
+	Values            string    `json:"values"`
+	Checksum          string    `json:"checksum"`
+	ReferenceChecksum string    `json:"reference_checksum"`
+	Diff              string    `json:"diff"` // The first difference
+}
+
+// ConsistencyQuerySummary compares the results of one query on one target with the reference's
+type ConsistencyQuerySummary struct {
+	Target         string  `json:"target"`
+	Query          string  `json:"query"`
+	Comparisons    int64   `json:"comparisons"`
+	Divergences    int64   `json:"divergences"`
+	Errors         int64   `json:"errors"`
+	DivergenceRate float64 `json:"divergence_rate"` // Share of the comparisons without errors
+}
+
+// ConsistencySummary is the cross-target consistency report of the run
+type ConsistencySummary struct {
+	Reference       string                    `json:"reference"`
+	ReferenceErrors int64                     `json:"reference_errors"` // Comparisons skipped because the reference failed
+	Queries         []ConsistencyQuerySummary `json:"queries"`
+	Divergences     int64                     `json:"divergences"`
+	Samples         []ConsistencyDiff         `json:"samples,omitempty"` // The first divergences
+}
+
+// consistencyCheck compares the targets of one run
+type consistencyCheck struct {
+	reference *Target
+	targets   []*Target
+	workload  *Workload
+	seeds     *seedSet
+	interval  time.Duration
+
+	mu              sync.Mutex
+	queries         map[[2]string]*ConsistencyQuerySummary // Keyed by target and query name
+	samples         []ConsistencyDiff
+	divergences     int64
+	referenceErrors int64
+}
+
+// newConsistencyCheck resolves the targets, builds the statements and fills in the defaults
+func newConsistencyCheck(cfg *ConsistencyConfig, targets []*Target) (*consistencyCheck, error) {
+	c := &consistencyCheck{
+		interval: cfg.Interval,
+		queries:  make(map[[2]string]*ConsistencyQuerySummary),
+	}
+	if c.interval <= 0 {
+		c.interval = time.Second
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	reference := cfg.reference(targets[0].Name)
+	compared := make(map[string]bool)
+	for _, name := range cfg.Targets {
+		compared[name] = true
+	}
+	for _, t := range targets {
+		switch {
+		case t.Name == reference:
+			c.reference = t
+		case len(compared) == 0 || compared[t.Name]:
+			c.targets = append(c.targets, t)
+		}
+	}
+	if c.reference == nil {
+		return nil, fmt.Errorf("unknown reference target %q", reference)
+	}
+
+	queries := make([]WeightedQuery, 0, len(cfg.Queries))
+	for i, q := range cfg.Queries {
+		if q.Name == "" {
+			q.Name = fmt.Sprintf("consistency_%d", i+1)
+		}
+		q.seeded = len(q.Params) == 0 && (cfg.SeedQuery != "" || cfg.SeedFile != "")
+		queries = append(queries, q)
+	}
+	workload, err := NewWorkload(queries)
+	if err != nil {
+		return nil, err
+	}
+	c.workload = workload
+	return c, nil
+}
+
+// RunConsistency compares the targets until ctx is cancelled
+func RunConsistency(ctx context.Context, cfg *ConsistencyConfig, targets []*Target, randomSeed uint64) *ConsistencySummary {
+	c, err := newConsistencyCheck(cfg, targets)
+	if err != nil {
+		log.Printf("Consistency check failed to start: %v", err)
+		return nil
+	}
+
+	// Every target is given the same seed values, loaded from the reference
+	if c.workload.needsSeed() {
+		sc := &ScenarioConfig{Name: "consistency", SeedQuery: cfg.SeedQuery, SeedFile: cfg.SeedFile}
+		c.seeds = &seedSet{name: sc.label(), target: c.reference.Name, load: seedLoader(c.reference, sc), ready: make(chan struct{})}
+		var loader sync.WaitGroup
+		loader.Add(1)
+		go func() {
+			defer loader.Done()
+			c.seeds.run(ctx, 0)
+		}()
+		defer loader.Wait()
+		if err := c.seeds.wait(ctx); err != nil {
+			return c.summary()
+		}
+		columns, _ := c.seeds.rows()
+		if err := c.workload.bindSeedColumns(columns); err != nil {
+			log.Printf("Consistency check failed to bind seed columns: %v", err)
+			return nil
+		}
+	}
+
+	log.Printf("Starting consistency checks of %d targets against %s every %v", len(c.targets), c.reference.Name, c.interval)
+	rng := newWorkerRand(randomSeed, 0, 0)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.compare(ctx, rng)
+		select {
+		case <-ctx.Done():
+			return c.summary()
+		case <-ticker.C:
+		}
+	}
+}
+
+// consistencyResult is the result of one statement on one target
+type consistencyResult struct {
+	columns []string
+	rows    []map[string]interface{}
+	err     error
+}
+
+// compare runs one statement with the same values on every target at once
+// and compares each target's result with the reference's
+func (c *consistencyCheck) compare(ctx context.Context, rng *rand.Rand) {
+	queryCtx, cancel := context.WithTimeout(ctx, c.interval)
+	defer cancel()
+
+	query := c.workload.Pick(rng)
+	_, rows := c.seeds.rows()
+	values := seedValues(query, rows, rng, nil)
+
+	targets := append([]*Target{c.reference}, c.targets...)
+	results := make([]consistencyResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *Target) {
+			defer wg.Done()
+			r := &results[i]
+			r.columns, r.rows, r.err = runStatement(queryCtx, paramRunner(target.DB, target.interpolates()), query.SQL, values)
+		}(i, target)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		// Stopped during the comparison
+		return
+	}
+
+	reference := results[0]
+	if reference.err != nil {
+		consistencyComparisons.WithLabelValues(c.reference.Name, query.Name, comparisonError).Inc()
+		log.Printf("Consistency query %s failed on reference %s: %v", query.Name, c.reference.Name, reference.err)
+		c.mu.Lock()
+		c.referenceErrors++
+		c.mu.Unlock()
+		return
+	}
+	referenceRows := sortRows(reference.columns, reference.rows)
+	referenceChecksum := resultChecksum(reference.columns, referenceRows)
+	for i, target := range c.targets {
+		r := results[i+1]
+		if r.err != nil {
+			log.Printf("Consistency query %s failed on %s: %v", query.Name, target.Name, r.err)
+			c.record(target.Name, query.Name, comparisonError, nil)
+			continue
+		}
+		rows := sortRows(r.columns, r.rows)
+		checksum := resultChecksum(r.columns, rows)
+		if checksum == referenceChecksum {
+			c.record(target.Name, query.Name, comparisonMatch, nil)
+			continue
+		}
+		c.record(target.Name, query.Name, comparisonDiverged, &ConsistencyDiff{
+			Time:              time.Now(),
+			Target:            target.Name,
+			Query:             query.Name,
+			Values:            fmt.Sprint(values),
+			Checksum:          checksum,
+			ReferenceChecksum: referenceChecksum,
+			Diff:              diffResults(reference.columns, referenceRows, r.columns, rows),
+		})
+	}
+}
+
+// record counts the comparison of one target and keeps a sample of the divergences
+func (c *consistencyCheck) record(target, query, result string, diff *ConsistencyDiff) {
+	consistencyComparisons.WithLabelValues(target, query, result).Inc()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := [2]string{target, query}
+	s, ok := c.queries[key]
+	if !ok {
+		s = &ConsistencyQuerySummary{Target: target, Query: query}
+		c.queries[key] = s
+	}
+	s.Comparisons++
+	switch result {
+	case comparisonError:
+		s.Errors++
+	case comparisonDiverged:
+		s.Divergences++
+		c.divergences++
+		if len(c.samples) < maxConsistencySamples {
+			c.samples = append(c.samples, *diff)
+		}
+	}
+}
+
+// sortRows returns the rows in the order of their encoded values, so results that only differ in row order compare equal.
+// Statements without an ORDER BY may return their rows in any order on every target.
+func sortRows(columns []string, rows []map[string]interface{}) []map[string]interface{} {
+	keys := make([]string, len(rows))
+	order := make([]int, len(rows))
+	for i, row := range rows {
+		keys[i] = rowKey(columns, row)
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+	sorted := make([]map[string]interface{}, len(rows))
+	for i, n := range order {
+		sorted[i] = rows[n]
+	}
+	return sorted
+}
+
+// diffResults describes the first difference between the reference's result and a target's, both sorted by sortRows
+func diffResults(referenceColumns []string, referenceRows []map[string]interface{}, columns []string, rows []map[string]interface{}) string {
+	if strings.Join(columns, ",") != strings.Join(referenceColumns, ",") {
+		return fmt.Sprintf("columns %v, reference %v", columns, referenceColumns)
+	}
+	// NULL is shown unquoted to tell it apart from the string
+	format := func(value interface{}) string {
+		if value == nil {
+			return "NULL"
+		}
+		return strconv.Quote(resultValue(value))
+	}
+	for i := 0; i < len(rows) && i < len(referenceRows); i++ {
+		for _, column := range columns {
+			value, expected := format(rows[i][column]), format(referenceRows[i][column])
+			if value != expected {
+				return fmt.Sprintf("row %d column %s is %s, reference %s", i+1, column, value, expected)
+			}
+		}
+	}
+	return fmt.Sprintf("%d rows, reference %d", len(rows), len(referenceRows))
+}
+
+// summary reports every compared query and target in order
+func (c *consistencyCheck) summary() *ConsistencySummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	summary := &ConsistencySummary{
+		Reference:       c.reference.Name,
+		ReferenceErrors: c.referenceErrors,
+		Divergences:     c.divergences,
+		Samples:         append([]ConsistencyDiff(nil), c.samples...),
+	}
+	for _, s := range c.queries {
+		q := *s
+		if compared := q.Comparisons - q.Errors; compared > 0 {
+			q.DivergenceRate = float64(q.Divergences) / float64(compared)
+		}
+		summary.Queries = append(summary.Queries, q)
+	}
+	sort.Slice(summary.Queries, func(i, j int) bool {
+		if summary.Queries[i].Target != summary.Queries[j].Target {
+			return summary.Queries[i].Target < summary.Queries[j].Target
+		}
+		return summary.Queries[i].Query < summary.Queries[j].Query
+	})
+	return summary
+}
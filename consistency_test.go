@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/exp/rand"
+)
+
+func TestConsistencyConfigCheck(t *testing.T) {
+	targets := []DatabaseConfig{{Name: "primary"}, {Name: "replica"}}
+	queries := []WeightedQuery{{SQL: "SELECT name FROM users WHERE id = ?"}}
+	tests := []struct {
+		cfg   ConsistencyConfig
+		valid bool
+	}{
+		{ConsistencyConfig{Queries: queries}, true},
+		{ConsistencyConfig{Reference: "replica", Targets: []string{"primary"}, Queries: queries}, true},
+		{ConsistencyConfig{}, false},
+		{ConsistencyConfig{Reference: "standby", Queries: queries}, false},
+		{ConsistencyConfig{Targets: []string{"primary"}, Queries: queries}, false},
+		{ConsistencyConfig{Queries: []WeightedQuery{{SQL: "UPDATE users SET name = 'x'"}}}, false},
+		{ConsistencyConfig{Queries: queries, SeedQuery: "SELECT id FROM users", SeedFile: "seeds.csv"}, false},
+	}
+	for _, test := range tests {
+		if err := test.cfg.check(targets); (err == nil) != test.valid {
+			t.Errorf("Expected valid=%v for %+v, got %v", test.valid, test.cfg, err)
+		}
+	}
+	if err := (&ConsistencyConfig{Queries: queries}).check(targets[:1]); err == nil {
+		t.Errorf("Expected error comparing a single target")
+	}
+}
+
+func TestConsistencyCompare(t *testing.T) {
+	resetMetrics()
+
+	primaryDB, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer primaryDB.Close()
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer replicaDB.Close()
+
+	targets := []*Target{
+		{Name: "primary", DB: sqlx.NewDb(primaryDB, "mysql")},
+		{Name: "replica", DB: sqlx.NewDb(replicaDB, "mysql")},
+	}
+	c, err := newConsistencyCheck(&ConsistencyConfig{
+		Queries:   []WeightedQuery{{Name: "user", SQL: "SELECT id, name FROM users WHERE id = :id"}},
+		SeedQuery: "SELECT id FROM users",
+	}, targets)
+	if err != nil {
+		t.Fatalf("Failed to set up consistency check: %v", err)
+	}
+	c.seeds = newStaticSeedSet([]string{"id"}, [][]interface{}{{int64(7)}})
+	if err := c.workload.bindSeedColumns([]string{"id"}); err != nil {
+		t.Fatalf("Failed to bind seed columns: %v", err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	rows := func(name string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name"}).AddRow(7, name)
+	}
+
+	// Both targets return the same row, then the replica serves a stale one, then it fails
+	primaryMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnRows(rows("alice"))
+	replicaMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnRows(rows("alice"))
+	c.compare(context.Background(), rng)
+	primaryMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnRows(rows("alice"))
+	replicaMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnRows(rows("bob"))
+	c.compare(context.Background(), rng)
+	primaryMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnRows(rows("alice"))
+	replicaMock.ExpectPrepare("SELECT id, name FROM users").ExpectQuery().WithArgs(int64(7)).WillReturnError(errors.New("connection refused"))
+	c.compare(context.Background(), rng)
+
+	if err := primaryMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet primary expectations: %v", err)
+	}
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet replica expectations: %v", err)
+	}
+	if v := testutil.ToFloat64(consistencyComparisons.WithLabelValues("replica", "user", comparisonDiverged)); v != 1 {
+		t.Errorf("Expected 1 divergence, got %v", v)
+	}
+
+	summary := c.summary()
+	if summary.Reference != "primary" || summary.Divergences != 1 || len(summary.Queries) != 1 {
+		t.Fatalf("Unexpected consistency summary: %+v", summary)
+	}
+	q := summary.Queries[0]
+	if q.Target != "replica" || q.Comparisons != 3 || q.Divergences != 1 || q.Errors != 1 || q.DivergenceRate != 0.5 {
+		t.Errorf("Unexpected query summary: %+v", q)
+	}
+	if len(summary.Samples) != 1 || summary.Samples[0].Values != "[7]" || summary.Samples[0].Diff != `row 1 column name is "bob", reference "alice"` {
+		t.Errorf("Unexpected divergence samples: %+v", summary.Samples)
+	}
+}
+
+func TestDiffResults(t *testing.T) {
+	columns := []string{"id", "name"}
+	reference := []map[string]interface{}{{"id": int64(1), "name": "alice"}, {"id": int64(2), "name": nil}}
+	tests := []struct {
+		columns  []string
+		rows     []map[string]interface{}
+		expected string
+	}{
+		{[]string{"id"}, nil, "columns [id], reference [id name]"},
+		{columns, reference[:1], "1 rows, reference 2"},
+		{columns, []map[string]interface{}{reference[0], {"id": int64(2), "name": "NULL"}}, `row 2 column name is "NULL", reference NULL`},
+	}
+	for _, test := range tests {
+		if diff := diffResults(columns, reference, test.columns, test.rows); diff != test.expected {
+			t.Errorf("Expected diff %q, got %q", test.expected, diff)
+		}
+	}
+}
+
+func TestSortRows(t *testing.T) {
+	columns := []string{"id", "name"}
+	rows := []map[string]interface{}{{"id": int64(2), "name": nil}, {"id": int64(1), "name": "bob"}, {"id": int64(1), "name": "alice"}}
+	reordered := []map[string]interface{}{rows[2], rows[0], rows[1]}
+
+	sorted := sortRows(columns, rows)
+	if !reflect.DeepEqual(sorted, sortRows(columns, reordered)) {
+		t.Errorf("Expected the same order of the same rows, got %v and %v", sorted, sortRows(columns, reordered))
+	}
+	if resultChecksum(columns, rows) == resultChecksum(columns, reordered) {
+		t.Errorf("Expected the row order to change the checksum of unsorted rows")
+	}
+	if rows[0]["id"] != int64(2) {
+		t.Errorf("Expected the rows to be sorted into a copy")
+	}
+}
+
+func TestRunConsistencyStops(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 4; i++ {
+		mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	}
+
+	sqlxDB := sqlx.NewDb(db, "mysql")
+	targets := []*Target{{Name: "primary", DB: sqlxDB}, {Name: "replica", DB: sqlxDB}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	summary := RunConsistency(ctx, &ConsistencyConfig{Queries: []WeightedQuery{{SQL: "SELECT 1"}}, Interval: time.Second}, targets, 1)
+	if summary == nil || len(summary.Queries) != 1 || summary.Queries[0].Comparisons != 1 || summary.Divergences != 0 {
+		t.Errorf("Expected one matching comparison before stopping, got %+v", summary)
+	}
+}
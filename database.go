@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"reflect"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -23,18 +26,24 @@ type DBWrapper struct {
 	Close func()
 }
 
-// InitializeDBWrapper initializes the DB connection and sets the appropriate configurations
-func InitializeDBWrapper(cfg *Config) (*DBWrapper, error) {
-	db, err := sqlx.Connect("mysql", cfg.Database.DSN)
+// InitializeDBWrapper initializes the DB connection of a target and sets the appropriate configurations
+func InitializeDBWrapper(target *DatabaseConfig) (*DBWrapper, error) {
+	// Connect through the timed connector to record connect phase latencies
+	connector, err := newTimedConnector(target.Name, target.DSN)
 	if err != nil {
 		return nil, err
 	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	// Set the connection pool parameters
-	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
-	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
-	db.SetConnMaxIdleTime(cfg.Database.ConnIdleTimeout) // This must be set before MaxLifeTime
-	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	db.SetMaxOpenConns(target.MaxOpenConns)
+	db.SetMaxIdleConns(target.MaxIdleConns)
+	db.SetConnMaxIdleTime(target.ConnIdleTimeout) // This must be set before MaxLifeTime
+	db.SetConnMaxLifetime(target.ConnMaxLifetime)
 
 	return &DBWrapper{
 		DB:    db,
@@ -43,54 +52,295 @@ func InitializeDBWrapper(cfg *Config) (*DBWrapper, error) {
 }
 
 // RunQueryWorkers runs multiple test queries in parallel within a single worker
-func RunQueryWorkers(cfg *Config, db *sqlx.DB, workerID int) {
-	numQueriesPerWorker := cfg.Database.QueriesPerWorker // Number of concurrent queries per worker
-	ticker := time.NewTicker(cfg.Database.QueryInterval)
-	defer ticker.Stop()
+func RunQueryWorkers(ctx context.Context, cfg *Config, sc *ScenarioConfig, target *Target, workerID int) {
+	numQueriesPerWorker := sc.QueriesPerWorker // Number of concurrent queries per worker
 
-	// Execute the seed query to fetch input values
-	_, inputValues, err := genericQuery(db, cfg.Database.SeedQuery, nil)
-	if err != nil || len(inputValues) == 0 {
-		queryErrors.WithLabelValues(fmt.Sprintf("%d", workerID), "worker_id").Inc()
-		log.Printf("[Worker %d] Failed to fetch seed values: %v", workerID, err)
+	run, err := prepareWorkload(ctx, cfg, sc, target, workerID)
+	if err != nil {
 		return
 	}
+	defer run.Close()
 
-	// Warm up the connection pool
-	warmUpConnections(db, cfg)
-
+	var wg sync.WaitGroup
 	for i := 0; i < numQueriesPerWorker; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runQueryLoop(ctx, sc, run, workerID, i)
+		}(i)
+	}
+	wg.Wait()
+}
 
-		log.Printf("Starting [Worker %d - Query %d]", workerID, i)
-		for {
-			select {
-			case <-ticker.C:
-				// Get a random index
-				randomIndex := rand.Intn(len(inputValues))
-				seedRow := inputValues[randomIndex]
-				// Prepare the value slice for the query execution from the seedData
-				var queryValues []interface{}
-				for _, value := range seedRow {
-					queryValues = append(queryValues, value)
-				}
-
-				startTime := time.Now() // Start time tracking
-				// Execute the query template with the seed values
-				_, rows, err := genericQuery(db, cfg.Database.QueryTemplate, queryValues)
-				duration := time.Since(startTime).Seconds() // Calculate duration
-				queryDuration.WithLabelValues(fmt.Sprintf("%d", workerID), "worker_id").Observe(duration)
-
-				if err != nil {
-					queryErrors.WithLabelValues(fmt.Sprintf("%d", workerID), "worker_id").Inc()
-					log.Printf("[Worker %d - Query %d] Query failed: %v\n", workerID, i, err)
-					continue
-				}
-				if debug {
-					log.Printf("[Worker %d - Query %d] Executed query: %v", workerID, i, rows)
-				}
-			}
+// queryRunner executes a statement and returns its columns and rows
+type queryRunner func(query string, values []interface{}) ([]string, []map[string]interface{}, error)
+
+// connectionCheck inspects the pooled connection that ran a statement once its latency has been recorded,
+// so the lookup isn't measured as part of the statement. Lookups go through runner, the connection
+// or the transaction the statement ran in. err is the statement's own error, if any.
+type connectionCheck func(ctx context.Context, conn *sqlx.Conn, runner sqlx.QueryerContext, query *WeightedQuery, err error)
+
+// checkedRunner is a connection or transaction that runs a checked statement and then its check
+type checkedRunner interface {
+	statementRunner
+	sqlx.QueryerContext
+}
+
+// scenarioRun holds what a worker needs to execute the statements of a scenario
+type scenarioRun struct {
+	target       string
+	workload     *Workload
+	seeds        *seedSet // Shared with the scenario's other workers on the target
+	runQuery     queryRunner
+	db           *sqlx.DB
+	check        connectionCheck // Pins each statement to a connection and runs after it, instead of runQuery
+	checkInTx    bool            // Runs each statement and its check in one transaction, which a proxy keeps on one backend
+	transaction  *transaction    // Runs instead of single statements when the scenario has a transaction
+	mode         string          // Statement mode the latencies are reported under, if the scenario sets one
+	picker       *seedPicker     // Seed row selection, uniform when nil
+	randomSeed   uint64          // Seeds the random sources of the worker's query streams, 0 for the time
+	interpolated bool            // The driver sends parameters as text, statements aren't prepared
+	closers      []func()
+}
+
+// next picks the next statement, or transaction, and its values. It runs on the dispatching goroutine
+// because rng isn't safe for concurrent use, the returned func may run on any.
+func (r *scenarioRun) next(rng *rand.Rand) func(workerID, i int, startTime time.Time) {
+	if r.transaction != nil {
+		_, rows := r.seeds.rows()
+		plan := r.transaction.plan(rng, rows, r.picker)
+		return func(workerID, i int, startTime time.Time) {
+			r.transaction.run(r, workerID, i, plan, startTime)
+		}
+	}
+	query := r.workload.Pick(rng)
+	_, rows := r.seeds.rows()
+	values := seedValues(query, rows, rng, r.picker)
+	return func(workerID, i int, startTime time.Time) {
+		runWorkloadQuery(r, workerID, i, query, values, startTime)
+	}
+}
+
+// Close releases anything the connection mode opened for the worker
+func (r *scenarioRun) Close() {
+	for _, closer := range r.closers {
+		closer()
+	}
+}
+
+// prepareWorkload builds the scenario's workload, fetches its seed values, warms up the target's pool
+// and sets up how queries reach the database
+func prepareWorkload(ctx context.Context, cfg *Config, sc *ScenarioConfig, target *Target, workerID int) (*scenarioRun, error) {
+	db := target.DB
+
+	// Build the weighted mix of statements to run
+	workload, err := buildWorkload(sc)
+	if err != nil {
+		log.Printf("[Worker %d] Failed to build workload: %v", workerID, err)
+		return nil, err
+	}
+	run := &scenarioRun{
+		target:   target.Name,
+		workload: workload,
+		db:       db,
+		runQuery: func(query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+			return genericQuery(target, query, values)
+		},
+		interpolated: target.interpolates(),
+	}
+	if sc.StatementMode != "" {
+		run.mode = statementModeLabel(sc.StatementMode, target.Config.DSN)
+	}
+	run.picker = newSeedPicker(sc.SeedDistribution, "seed/"+target.Name+"/"+sc.label(), workerID)
+	run.randomSeed = uint64(cfg.RandomSeed)
+
+	// Wait for the seed values shared by the scenario's workers, loading them if this is the first worker
+	if workload.needsSeed() {
+		run.seeds = seedSets.get(target, sc, workerID)
+		if err := run.seeds.wait(ctx); err != nil {
+			return nil, err
+		}
+		columns, _ := run.seeds.rows()
+		if err := workload.bindSeedColumns(columns); err != nil {
+			log.Printf("[Worker %d] Failed to bind seed columns: %v", workerID, err)
+			return nil, err
+		}
+	}
+
+	// The connection mode and the settings combined with it were checked before connecting
+	switch sc.ConnectionMode {
+	case connectionModeChurn:
+		// Dial a fresh connection for every query instead of using the pool
+		churn, err := newChurnDB(target.Name, target.Config.DSN, sc.label())
+		if err != nil {
+			log.Printf("[Worker %d] Failed to set up connection churn: %v", workerID, err)
+			return nil, err
+		}
+		run.runQuery = churn.query
+		run.closers = append(run.closers, churn.Close)
+	default:
+		// Warm up the connection pool
+		warmUpConnections(db, target.Config)
+		switch {
+		case sc.TrackIdentity:
+			run.check = identities.check(target, sc.label())
+		case sc.VerifySplit:
+			run.check = splitChecks.check(target.Name, sc.label())
+			run.checkInTx = true
+		}
+		if sc.StatementMode == statementModePrepared {
+			run.runQuery = preparedStmts.query(db, target.Name)
+		}
+		if sc.Transaction != nil {
+			run.transaction = newTransaction(sc, db, workload)
+		}
+	}
+
+	return run, nil
+}
+
+// runQueryLoop runs one query stream of a worker at the scenario's query interval
+func runQueryLoop(ctx context.Context, sc *ScenarioConfig, run *scenarioRun, workerID, i int) {
+	ticker := time.NewTicker(sc.QueryInterval)
+	defer ticker.Stop()
+
+	rng := newWorkerRand(run.randomSeed, workerID, i)
+
+	log.Printf("Starting [Worker %d - Query %d]", workerID, i)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Stopping [Worker %d - Query %d]", workerID, i)
+			return
+		case <-ticker.C:
+			run.next(rng)(workerID, i, time.Now())
+		}
+	}
+}
+
+// newWorkerRand returns a random source for a single query stream.
+// A non-zero seed makes the stream, and so the picked statements and values, the same on every run.
+func newWorkerRand(seed uint64, workerID, i int) *rand.Rand {
+	if seed == 0 {
+		seed = uint64(time.Now().UnixNano())
+	}
+	return rand.New(rand.NewSource(seed + uint64(workerID*1000+i)))
+}
+
+// seedValues prepares the value slice for the query execution from its generators or a seed row chosen by picker.
+// The seed row is bound by the column indexes of the statement's placeholder names, or else in column order.
+func seedValues(query *WeightedQuery, inputValues [][]interface{}, rng *rand.Rand, picker *seedPicker) []interface{} {
+	if len(query.generators) > 0 {
+		return query.generate(rng)
+	}
+	if !query.seeded {
+		return nil
+	}
+
+	seedRow := inputValues[picker.pick(rng, len(inputValues))]
+	if query.columns == nil {
+		return append([]interface{}(nil), seedRow...)
+	}
+	queryValues := make([]interface{}, len(query.columns))
+	for i, column := range query.columns {
+		queryValues[i] = seedRow[column]
+	}
+	return queryValues
+}
+
+// runWorkloadQuery executes one statement and records its latency measured from startTime
+func runWorkloadQuery(run *scenarioRun, workerID, i int, query *WeightedQuery, queryValues []interface{}, startTime time.Time) {
+	if run.check != nil {
+		runCheckedQuery(run, workerID, i, query, queryValues, startTime)
+		return
+	}
+
+	// Execute the selected statement with the seed values
+	columns, rows, err := run.runQuery(query.SQL, queryValues)
+	if err == nil {
+		err = validateResult(query, queryValues, columns, rows)
+	}
+	recordWorkloadQuery(run, workerID, i, query, rows, time.Since(startTime), err)
+}
+
+// runCheckedQuery executes one statement on a pinned connection and runs the scenario's check
+// on that connection after the statement's latency has been recorded
+func runCheckedQuery(run *scenarioRun, workerID, i int, query *WeightedQuery, queryValues []interface{}, startTime time.Time) {
+	ctx := context.Background()
+	conn, err := run.db.Connx(ctx)
+	if err != nil {
+		recordWorkloadQuery(run, workerID, i, query, nil, time.Since(startTime), &phaseError{phase: phaseConnect, err: err})
+		return
+	}
+	defer conn.Close()
+
+	// Reads start read-only transactions, which a proxy can route to the readers like the read itself
+	var runner checkedRunner = conn
+	var tx *sqlx.Tx
+	var begin time.Duration
+	if run.checkInTx {
+		beginStart := time.Now()
+		tx, err = conn.BeginTxx(ctx, &sql.TxOptions{ReadOnly: query.kind() == kindRead})
+		if err != nil {
+			recordWorkloadQuery(run, workerID, i, query, nil, time.Since(startTime), &phaseError{phase: phaseExecute, err: err})
+			return
 		}
+		begin = time.Since(beginStart)
+		runner = tx
+	}
 
+	columns, rows, stmtErr := runStatement(ctx, paramRunner(runner, run.interpolated), query.SQL, queryValues)
+	err = stmtErr
+	if err == nil {
+		err = validateResult(query, queryValues, columns, rows)
+	}
+	// The BEGIN isn't part of the statement's latency
+	recordWorkloadQuery(run, workerID, i, query, rows, time.Since(startTime)-begin, err)
+	run.check(ctx, conn, runner, query, stmtErr)
+	if tx == nil {
+		return
+	}
+	if stmtErr != nil || query.kind() == kindRead {
+		tx.Rollback()
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		recordQueryError(run.target, workerID, query.Name, &phaseError{phase: phaseExecute, err: err})
+	}
+}
+
+// recordWorkloadQuery records the latency and error, or the rows affected, of one statement
+func recordWorkloadQuery(run *scenarioRun, workerID, i int, query *WeightedQuery, rows []map[string]interface{}, duration time.Duration, err error) {
+	queryDuration.WithLabelValues(run.target, fmt.Sprintf("%d", workerID), query.Name).Observe(duration.Seconds())
+	runStats.Record(run.target, workerID, query.Name, duration, err)
+	if run.mode != "" {
+		statementModeDuration.WithLabelValues(run.target, run.mode).Observe(duration.Seconds())
+		runStats.RecordMode(run.mode, duration, err)
+	}
+	// A wrong result is not an outage
+	var mismatch *resultMismatch
+	if errors.As(err, &mismatch) {
+		timeline.Record(run.target, nil)
+	} else {
+		timeline.Record(run.target, err)
+	}
+
+	if err != nil {
+		class := recordQueryError(run.target, workerID, query.Name, err)
+		log.Printf("[Worker %d - Query %d] Query %s failed (%s): %v\n", workerID, i, query.Name, class, err)
+		return
+	}
+	if !returnsRows(query.SQL) && len(rows) == 1 {
+		affected, _ := rows[0]["rows_affected"].(int64)
+		lastID, _ := rows[0]["last_insert_id"].(int64)
+		rowsAffected.WithLabelValues(run.target, query.Name).Add(float64(affected))
+		if lastID != 0 {
+			lastInsertID.WithLabelValues(run.target, query.Name).Set(float64(lastID))
+		}
+		runStats.RecordWrite(run.target, query.Name, affected, lastID)
+	}
+	if debug {
+		log.Printf("[Worker %d - Query %d] Executed query %s: %v", workerID, i, query.Name, rows)
 	}
 }
 
@@ -142,48 +392,114 @@ func convertValue(val interface{}) interface{} {
 	}
 }
 
-// genericQuery runs a query and returns columns and rows with their proper types
-func genericQuery(db *sqlx.DB, query string, values []interface{}) ([]string, []map[string]interface{}, error) {
-	// Execute the query
-	rows, err := db.Queryx(query, values...)
+// genericQuery runs a query and returns columns and rows with their proper types.
+// Errors are wrapped with the phase they happened in.
+func genericQuery(target *Target, query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+	ctx := context.Background()
+
+	// Check out a connection, dialing a new one if the pool has none idle
+	conn, err := target.DB.Connx(ctx)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseConnect, err: err}
+	}
+	defer conn.Close()
+
+	return runStatement(ctx, paramRunner(conn, target.interpolates()), query, values)
+}
+
+// execColumns are the columns of the single row reported for a statement without a result set
+var execColumns = []string{"rows_affected", "last_insert_id"}
+
+// statementRunner is a connection or transaction that statements run on
+type statementRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+}
+
+// statementPreparer is a statement runner that can prepare statements explicitly
+type statementPreparer interface {
+	PreparexContext(ctx context.Context, query string) (*sqlx.Stmt, error)
+}
+
+// interpolatedRunner runs statements on a connection whose driver interpolates their parameters
+// into the SQL text, so runStatement doesn't prepare them
+type interpolatedRunner struct {
+	statementRunner
+}
+
+// paramRunner returns the runner for a connection, hiding its prepare if the driver interpolates parameters
+func paramRunner(conn statementRunner, interpolated bool) statementRunner {
+	if interpolated {
+		return interpolatedRunner{conn}
+	}
+	return conn
+}
+
+// prepareStatement prepares statements with parameters explicitly, as the driver would do implicitly,
+// so that prepare errors are reported apart from execute errors. The returned func closes the statement.
+func prepareStatement(ctx context.Context, conn statementRunner, query string, values []interface{}) (statementRunner, func(), error) {
+	preparer, ok := conn.(statementPreparer)
+	if !ok || len(values) == 0 {
+		return conn, func() {}, nil
+	}
+	stmt, err := preparer.PreparexContext(ctx, query)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phasePrepare, err: err}
+	}
+	return preparedStatement{stmt}, func() { stmt.Close() }, nil
+}
+
+// runStatement queries statements that return a result set and executes the others,
+// reporting their rows affected and last insert ID as a single row.
+func runStatement(ctx context.Context, conn statementRunner, query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+	conn, closeStmt, err := prepareStatement(ctx, conn, query, values)
 	if err != nil {
 		return nil, nil, err
 	}
+	// Deferred before the rows are, so the statement is closed after them
+	defer closeStmt()
+
+	if !returnsRows(query) {
+		result, err := conn.ExecContext(ctx, query, values...)
+		if err != nil {
+			return nil, nil, &phaseError{phase: phaseExecute, err: err}
+		}
+		// The MySQL driver always knows both, other drivers may not
+		affected, _ := result.RowsAffected()
+		lastID, _ := result.LastInsertId()
+		return execColumns, []map[string]interface{}{{"rows_affected": affected, "last_insert_id": lastID}}, nil
+	}
+
+	rows, err := conn.QueryxContext(ctx, query, values...)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseExecute, err: err}
+	}
 	defer rows.Close()
 
+	return scanRows(rows)
+}
+
+// scanRows reads every row of a result set into column name to value maps
+func scanRows(rows *sqlx.Rows) ([]string, []map[string]interface{}, error) {
 	// Get column names
 	columns, err := rows.Columns()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, &phaseError{phase: phaseRows, err: err}
 	}
 
 	// Prepare a slice to hold the result set
 	var result []map[string]interface{}
 
-	// Create a slice of interface{}'s to hold each row's column values
-	// and a slice of pointers to each value for scanning
 	for rows.Next() {
-		columnPointers := make([]interface{}, len(columns))
-		columnValues := make([]interface{}, len(columns))
-		for i := range columnValues {
-			columnPointers[i] = &columnValues[i]
-		}
-
-		// Scan the row into columnPointers
-		if err := rows.Scan(columnPointers...); err != nil {
+		columnValues, err := scanRow(rows, len(columns))
+		if err != nil {
 			return nil, nil, err
 		}
 
 		// Create a map to store the column name and value for each row
-		rowMap := make(map[string]interface{})
+		rowMap := make(map[string]interface{}, len(columns))
 		for i, colName := range columns {
-			val := columnValues[i]
-			// Check if the value is a byte slice (usually for strings or blobs)
-			if b, ok := val.([]byte); ok {
-				rowMap[colName] = string(b)
-			} else {
-				rowMap[colName] = convertToProperType(val)
-			}
+			rowMap[colName] = columnValues[i]
 		}
 
 		// Append the row to the result set
@@ -192,12 +508,34 @@ func genericQuery(db *sqlx.DB, query string, values []interface{}) ([]string, []
 
 	// Check for any error encountered during iteration
 	if err := rows.Err(); err != nil {
-		return nil, nil, err
+		return nil, nil, &phaseError{phase: phaseRows, err: err}
 	}
 
 	return columns, result, nil
 }
 
+// scanRow scans the current row into a slice of n values, byte slices (usually strings or blobs) becoming strings
+func scanRow(rows *sqlx.Rows, n int) ([]interface{}, error) {
+	// Create a slice of interface{}'s to hold the row's column values
+	// and a slice of pointers to each value for scanning
+	columnPointers := make([]interface{}, n)
+	columnValues := make([]interface{}, n)
+	for i := range columnValues {
+		columnPointers[i] = &columnValues[i]
+	}
+	if err := rows.Scan(columnPointers...); err != nil {
+		return nil, &phaseError{phase: phaseRows, err: err}
+	}
+	for i, val := range columnValues {
+		if b, ok := val.([]byte); ok {
+			columnValues[i] = string(b)
+		} else {
+			columnValues[i] = convertToProperType(val)
+		}
+	}
+	return columnValues, nil
+}
+
 // convertToProperType converts SQL types to Go's types
 func convertToProperType(value interface{}) interface{} {
 	if value == nil {
@@ -217,12 +555,12 @@ func convertToProperType(value interface{}) interface{} {
 }
 
 // warmUpConnections performs simple queries to establish idle connections
-func warmUpConnections(db *sqlx.DB, cfg *Config) error {
+func warmUpConnections(db *sqlx.DB, target *DatabaseConfig) error {
 
-	//db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
-	db.SetConnMaxIdleTime(cfg.Database.ConnIdleTimeout)
+	//db.SetConnMaxLifetime(target.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(target.ConnIdleTimeout)
 	//db.SetConnMaxIdleTime(7 * time.Second)
-	for i := 0; i < cfg.Database.NumIdleConnections; i++ {
+	for i := 0; i < target.NumIdleConnections; i++ {
 		log.Printf("Warmed up connection: %d", i+1)
 		go func() {
 
@@ -237,14 +575,3 @@ func warmUpConnections(db *sqlx.DB, cfg *Config) error {
 	}
 	return nil
 }
-
-func collectDBPoolMetrics(db *sqlx.DB, poolName string, interval time.Duration) {
-	for {
-		stats := db.Stats()
-		openConnections.WithLabelValues(poolName).Set(float64(stats.OpenConnections))
-		idleConnections.WithLabelValues(poolName).Set(float64(stats.Idle))
-		inUseConnections.WithLabelValues(poolName).Set(float64(stats.InUse))
-		time.Sleep(interval) // Collect metrics every time duration
-	}
-	return
-}
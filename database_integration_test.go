@@ -144,7 +144,7 @@ func TestInitializeDB(t *testing.T) {
 	}
 
 	// Test InitializeDB function
-	dbWrapper, err := InitializeDBWrapper(cfg)
+	dbWrapper, err := InitializeDBWrapper(&cfg.Database)
 	if err != nil {
 
 		t.Fatalf("InitializeDB failed: %v", err)
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/exp/rand"
 )
 
 // TestInitializeDBWrapper verifies the InitializeDBWrapper function
@@ -29,7 +32,7 @@ func TestInitializeDBWrapper(t *testing.T) {
 	}
 
 	// Test InitializeDBWrapper function
-	dbWrapper, err := InitializeDBWrapper(cfg)
+	dbWrapper, err := InitializeDBWrapper(&cfg.Database)
 	if err != nil {
 		t.Fatalf("InitializeDBWrapper failed: %v", err)
 	}
@@ -87,7 +90,7 @@ func TestRunQueryWorkers(t *testing.T) {
 	}
 
 	// Set up test database table and data
-	dbWrapper, err := InitializeDBWrapper(cfg)
+	dbWrapper, err := InitializeDBWrapper(&cfg.Database)
 	if err != nil {
 		t.Fatalf("Failed to initialize database wrapper: %v", err)
 	}
@@ -112,7 +115,9 @@ func TestRunQueryWorkers(t *testing.T) {
 	}
 
 	// Call RunQueryWorkers
-	go RunQueryWorkers(cfg, dbWrapper.DB, 1)
+	scenarios := cfg.ScenarioConfigs()
+	target := &Target{Name: cfg.Database.poolName(), Config: &cfg.Database, DB: dbWrapper.DB}
+	go RunQueryWorkers(context.Background(), cfg, &scenarios[0], target, 1)
 
 	// Allow some time for the workers to run
 	time.Sleep(1 * time.Second)
@@ -120,3 +125,75 @@ func TestRunQueryWorkers(t *testing.T) {
 	// No explicit assertions needed since we're testing code coverage
 	t.Logf("TestRunQueryWorkers completed successfully")
 }
+
+// Test that statements without a result set are executed and report their rows affected and insert ID
+func TestRunWorkloadQueryWrite(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+	sequences.Delete("insert/0")
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	workload, err := NewWorkload([]WeightedQuery{
+		{Name: "insert", SQL: "INSERT INTO users (id, name) VALUES (?, ?)", Params: []string{"seq:7", "choice:alice"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	run := &scenarioRun{
+		target:   "primary",
+		workload: workload,
+		runQuery: func(query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+			return genericQuery(&Target{DB: sqlxDB}, query, values)
+		},
+	}
+
+	mock.ExpectPrepare("INSERT INTO users").ExpectExec().WithArgs(int64(7), "alice").WillReturnResult(sqlmock.NewResult(42, 1))
+	query := workload.Pick(rand.New(rand.NewSource(1)))
+	runWorkloadQuery(run, 1, 1, query, seedValues(query, nil, rand.New(rand.NewSource(1)), nil), time.Now())
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	writes := runStats.Summary(time.Second, PoolSummary{}).Writes
+	expected := WriteQuerySummary{Target: "primary", Query: "insert", Statements: 1, RowsAffected: 1, LastInsertID: 42}
+	if len(writes) != 1 || writes[0] != expected {
+		t.Errorf("Expected writes %+v, got %+v", expected, writes)
+	}
+	if v := testutil.ToFloat64(rowsAffected.WithLabelValues("primary", "insert")); v != 1 {
+		t.Errorf("Expected 1 row affected, got %v", v)
+	}
+}
+
+func TestSeedValuesColumnOrder(t *testing.T) {
+	columns := []string{"user_id", "status", "region", "tier"}
+	rows := [][]interface{}{{7, "open", "eu", 2}}
+	rng := rand.New(rand.NewSource(1))
+
+	// Positional placeholders take the seed columns in order, every time
+	query := &WeightedQuery{Name: "orders", SQL: "SELECT * FROM orders WHERE user_id = ? AND status = ? AND region = ? AND tier = ?", seeded: true}
+	for i := 0; i < 20; i++ {
+		values := seedValues(query, rows, rng, nil)
+		if len(values) != 4 || values[0] != 7 || values[1] != "open" || values[2] != "eu" || values[3] != 2 {
+			t.Fatalf("Expected values in seed column order, got %v", values)
+		}
+	}
+
+	// Named placeholders are bound by the index of the column of the same name
+	workload, err := NewWorkload([]WeightedQuery{{Name: "orders", SQL: "SELECT * FROM orders WHERE status = :status AND user_id = :user_id", seeded: true}})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	if err := workload.bindSeedColumns(columns); err != nil {
+		t.Fatalf("Failed to bind seed columns: %v", err)
+	}
+	query = &workload.Queries()[0]
+	if values := seedValues(query, rows, rng, nil); len(values) != 2 || values[0] != "open" || values[1] != 7 {
+		t.Errorf("Expected values bound by name, got %v", values)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// dialTiming collects how long the parts of opening a connection took,
+// and the network error that broke the connection afterwards
+type dialTiming struct {
+	dns      time.Duration
+	tcp      time.Duration
+	tlsStart time.Time // First write after connecting, the TLS request when TLS is used
+	tlsDone  time.Time
+
+	mu    sync.Mutex
+	ioErr error
+}
+
+// setIOError records a failed read or write on the connection. Only the first one is kept,
+// the later ones come from the driver closing the broken connection.
+func (t *dialTiming) setIOError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ioErr == nil {
+		t.ioErr = err
+	}
+}
+
+// ioError returns the first failed read or write on the connection. The driver reports every one of them
+// as ErrInvalidConn or ErrBadConn, this is what actually happened to the connection.
+func (t *dialTiming) ioError() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.ioErr
+}
+
+// tls returns the TLS handshake duration, or zero when TLS wasn't used
+func (t *dialTiming) tls() time.Duration {
+	if t.tlsDone.IsZero() || t.tlsStart.IsZero() {
+		return 0
+	}
+	return t.tlsDone.Sub(t.tlsStart)
+}
+
+type dialTimingKey struct{}
+
+// withDialTiming returns a context that the timed dial functions report into
+func withDialTiming(ctx context.Context, timing *dialTiming) context.Context {
+	return context.WithValue(ctx, dialTimingKey{}, timing)
+}
+
+var (
+	timedNetsMu sync.Mutex
+	timedNets   = map[string]bool{}
+)
+
+// timedNet registers a dial function with the mysql driver that times the DNS lookup
+// and TCP connect of the given network, and returns the network name to use in the driver config
+func timedNet(network string) string {
+	name := "timed_" + network
+
+	timedNetsMu.Lock()
+	defer timedNetsMu.Unlock()
+	if !timedNets[name] {
+		mysql.RegisterDialContext(name, func(ctx context.Context, addr string) (net.Conn, error) {
+			timing, ok := ctx.Value(dialTimingKey{}).(*dialTiming)
+			if !ok {
+				timing = &dialTiming{}
+			}
+			conn, err := timedDial(ctx, network, addr, timing)
+			if err != nil {
+				return nil, err
+			}
+			return &timedConn{Conn: conn, timing: timing}, nil
+		})
+		timedNets[name] = true
+	}
+	return name
+}
+
+// timedDial resolves the host and connects to the first address that accepts
+func timedDial(ctx context.Context, network, addr string, timing *dialTiming) (net.Conn, error) {
+	var dialer net.Dialer
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Unix sockets and other networks without a host to resolve
+		start := time.Now()
+		conn, err := dialer.DialContext(ctx, network, addr)
+		timing.tcp = time.Since(start)
+		return conn, err
+	}
+
+	start := time.Now()
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	timing.dns = time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+
+	start = time.Now()
+	defer func() { timing.tcp = time.Since(start) }()
+	var errs []error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// timedConn notes the first write on a new connection, which starts the TLS handshake when TLS is used,
+// and the network errors that the driver hides behind its own
+type timedConn struct {
+	net.Conn
+	timing *dialTiming
+}
+
+func (c *timedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.timing.setIOError(err)
+	}
+	return n, err
+}
+
+func (c *timedConn) Write(b []byte) (int, error) {
+	if c.timing.tlsStart.IsZero() {
+		c.timing.tlsStart = time.Now()
+	}
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.timing.setIOError(err)
+	}
+	return n, err
+}
+
+// SyscallConn exposes the socket so the driver can still check pooled connections for liveness
+func (c *timedConn) SyscallConn() (syscall.RawConn, error) {
+	sysConn, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connection does not support SyscallConn")
+	}
+	return sysConn.SyscallConn()
+}
+
+// timedConnector opens connections through the timed dial function and records
+// DNS, TCP connect, TLS handshake and MySQL auth durations for every new physical connection
+type timedConnector struct {
+	cfg    *mysql.Config
+	target string
+	host   string
+}
+
+// newTimedConnector parses the DSN of a target and routes its connections through the timed dial function
+func newTimedConnector(target, dsn string) (*timedConnector, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	host := cfg.Addr
+	cfg.Net = timedNet(cfg.Net)
+	return &timedConnector{cfg: cfg, target: target, host: host}, nil
+}
+
+// Connect implements driver.Connector.
+// Callers can pass their own *dialTiming in the context to read the timings back.
+func (c *timedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	timing, ok := ctx.Value(dialTimingKey{}).(*dialTiming)
+	if !ok {
+		timing = &dialTiming{}
+		ctx = withDialTiming(ctx, timing)
+	}
+
+	// A per-connection TLS config lets the handshake report when it finished
+	cfg := c.cfg.Clone()
+	if cfg.TLS != nil {
+		verify := cfg.TLS.VerifyConnection
+		cfg.TLS.VerifyConnection = func(cs tls.ConnectionState) error {
+			timing.tlsDone = time.Now()
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+	}
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	conn, err := connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	total := time.Since(start)
+
+	tlsDuration := timing.tls()
+	connectPhaseDuration.WithLabelValues(c.target, c.host, "dns").Observe(timing.dns.Seconds())
+	connectPhaseDuration.WithLabelValues(c.target, c.host, "tcp").Observe(timing.tcp.Seconds())
+	if tlsDuration > 0 {
+		connectPhaseDuration.WithLabelValues(c.target, c.host, "tls").Observe(tlsDuration.Seconds())
+	}
+	connectPhaseDuration.WithLabelValues(c.target, c.host, "auth").Observe((total - timing.dns - timing.tcp - tlsDuration).Seconds())
+	return conn, nil
+}
+
+// Driver implements driver.Connector
+func (c *timedConnector) Driver() driver.Driver {
+	return &mysql.MySQLDriver{}
+}
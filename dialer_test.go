@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimedDial(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// Host names are resolved before connecting
+	timing := &dialTiming{}
+	conn, err := timedDial(context.Background(), "tcp", net.JoinHostPort("localhost", port), timing)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	if timing.dns <= 0 || timing.tcp <= 0 {
+		t.Errorf("Expected DNS and TCP timings, got %+v", timing)
+	}
+
+	// The first write marks the start of a possible TLS handshake
+	timed := &timedConn{Conn: conn, timing: timing}
+	if _, err := timed.Write([]byte("ping")); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if timing.tlsStart.IsZero() {
+		t.Errorf("Expected first write to be recorded")
+	}
+	if timing.tls() != 0 {
+		t.Errorf("Expected no TLS duration without a handshake, got %v", timing.tls())
+	}
+	timing.tlsDone = timing.tlsStart.Add(5 * time.Millisecond)
+	if timing.tls() != 5*time.Millisecond {
+		t.Errorf("Expected 5ms TLS duration, got %v", timing.tls())
+	}
+
+	// The wrapped connection still exposes its socket for the driver's liveness check
+	if _, err := timed.SyscallConn(); err != nil {
+		t.Errorf("Expected SyscallConn to be available: %v", err)
+	}
+}
+
+func TestNewTimedConnector(t *testing.T) {
+	connector, err := newTimedConnector("primary", "user:password@tcp(db1:3306)/testdb")
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	if connector.target != "primary" || connector.host != "db1:3306" || connector.cfg.Net != "timed_tcp" {
+		t.Errorf("Unexpected connector: target %s host %s net %s", connector.target, connector.host, connector.cfg.Net)
+	}
+
+	if _, err := newTimedConnector("primary", "not a dsn"); err == nil {
+		t.Errorf("Expected error for an invalid DSN")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/exp/rand"
+)
+
+// Seed distributions
+const (
+	distributionUniform    = "uniform"
+	distributionZipfian    = "zipfian"
+	distributionHotspot    = "hotspot"
+	distributionSequential = "sequential"
+	distributionRoundRobin = "round_robin"
+)
+
+// SeedDistributionConfig decides which seed row each statement is bound to.
+// Zipfian and hotspot treat the first seed rows as the most popular ones, so order the seed query to match.
+type SeedDistributionConfig struct {
+	Type       string   `yaml:"type"`        // uniform, zipfian, hotspot, sequential (shared by all workers) or round_robin (per worker), defaults to uniform
+	Skew       float64  `yaml:"skew"`        // Zipfian exponent, larger than 1. Defaults to 1.1
+	HotTraffic *float64 `yaml:"hot_traffic"` // Hotspot share of picks that go to the hot rows. Defaults to 0.8
+	HotKeys    *float64 `yaml:"hot_keys"`    // Hotspot share of the seed rows that are hot. Defaults to 0.2
+}
+
+// UnmarshalYAML accepts either a plain distribution type or a type/skew/hot_traffic/hot_keys mapping
+func (d *SeedDistributionConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var distribution string
+	if err := unmarshal(&distribution); err == nil {
+		d.Type = distribution
+		return nil
+	}
+
+	type plain SeedDistributionConfig
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*d = SeedDistributionConfig(p)
+	return nil
+}
+
+// validate checks the type and parameters of the distribution
+func (d *SeedDistributionConfig) validate() error {
+	switch d.Type {
+	case "", distributionUniform, distributionSequential, distributionRoundRobin:
+	case distributionZipfian:
+		if d.Skew != 0 && d.Skew <= 1 {
+			return fmt.Errorf("zipfian skew %g must be larger than 1", d.Skew)
+		}
+	case distributionHotspot:
+		for _, share := range []*float64{d.HotTraffic, d.HotKeys} {
+			if share != nil && (*share < 0 || *share > 1) {
+				return fmt.Errorf("hotspot hot_traffic and hot_keys must be between 0 and 1")
+			}
+		}
+	default:
+		return fmt.Errorf("unknown seed distribution %q", d.Type)
+	}
+	return nil
+}
+
+// seedPicker chooses seed rows by a scenario's seed distribution. A nil picker picks uniformly.
+type seedPicker struct {
+	cfg        SeedDistributionConfig
+	hotTraffic float64
+	hotKeys    float64
+	cursor     *int64 // Next row of the sequential and round robin distributions
+
+	mu    sync.Mutex
+	zipfs map[*rand.Rand]*zipfSource // A Zipf generator draws from the random source of one query stream
+}
+
+// zipfSource draws zipfian row indexes out of n seed rows
+type zipfSource struct {
+	n    int
+	zipf *rand.Zipf
+}
+
+// newSeedPicker fills in the defaults of the distribution. Sequential pickers with the same key share their position,
+// round robin pickers start at the worker's own row.
+func newSeedPicker(cfg *SeedDistributionConfig, key string, workerID int) *seedPicker {
+	if cfg == nil {
+		return nil
+	}
+	p := &seedPicker{cfg: *cfg, hotTraffic: 0.8, hotKeys: 0.2, cursor: new(int64)}
+	switch p.cfg.Type {
+	case distributionZipfian:
+		if p.cfg.Skew == 0 {
+			p.cfg.Skew = 1.1
+		}
+		p.zipfs = make(map[*rand.Rand]*zipfSource)
+	case distributionHotspot:
+		// An explicit 0 is kept, only unset shares fall back to the defaults
+		if p.cfg.HotTraffic != nil {
+			p.hotTraffic = *p.cfg.HotTraffic
+		}
+		if p.cfg.HotKeys != nil {
+			p.hotKeys = *p.cfg.HotKeys
+		}
+	case distributionSequential:
+		cursor, _ := sequences.LoadOrStore(key, new(int64))
+		p.cursor = cursor.(*int64)
+	case distributionRoundRobin:
+		*p.cursor = int64(workerID)
+	}
+	return p
+}
+
+// pick returns the index of the next seed row out of n
+func (p *seedPicker) pick(rng *rand.Rand, n int) int {
+	if p == nil || n <= 1 {
+		return rng.Intn(n)
+	}
+	switch p.cfg.Type {
+	case distributionZipfian:
+		return int(p.zipf(rng, n).Uint64())
+	case distributionHotspot:
+		hot := int(math.Ceil(p.hotKeys * float64(n)))
+		if hot >= n || (hot > 0 && rng.Float64() < p.hotTraffic) {
+			return rng.Intn(hot)
+		}
+		return hot + rng.Intn(n-hot)
+	case distributionSequential, distributionRoundRobin:
+		return int((atomic.AddInt64(p.cursor, 1) - 1) % int64(n))
+	default:
+		return rng.Intn(n)
+	}
+}
+
+// zipf returns the Zipf generator of a query stream's random source for n seed rows.
+// It is built again only when the seed set changes size.
+func (p *seedPicker) zipf(rng *rand.Rand, n int) *rand.Zipf {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	z, ok := p.zipfs[rng]
+	if !ok || z.n != n {
+		z = &zipfSource{n: n, zipf: rand.NewZipf(rng, p.cfg.Skew, 1, uint64(n-1))}
+		p.zipfs[rng] = z
+	}
+	return z.zipf
+}
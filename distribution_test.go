@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/exp/rand"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSeedDistributionConfig(t *testing.T) {
+	var cfg struct {
+		Plain  *SeedDistributionConfig `yaml:"plain"`
+		Mapped *SeedDistributionConfig `yaml:"mapped"`
+	}
+	if err := yaml.Unmarshal([]byte("plain: sequential\nmapped:\n  type: zipfian\n  skew: 1.5\n"), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal seed distributions: %v", err)
+	}
+	if cfg.Plain.Type != distributionSequential || cfg.Mapped.Type != distributionZipfian || cfg.Mapped.Skew != 1.5 {
+		t.Errorf("Unexpected seed distributions: %+v %+v", cfg.Plain, cfg.Mapped)
+	}
+
+	tooLarge := 1.2
+	for _, invalid := range []SeedDistributionConfig{
+		{Type: "gaussian"},
+		{Type: distributionZipfian, Skew: 0.9},
+		{Type: distributionHotspot, HotTraffic: &tooLarge},
+	} {
+		if err := invalid.validate(); err == nil {
+			t.Errorf("Expected error for %+v", invalid)
+		}
+	}
+}
+
+func TestSeedPicker(t *testing.T) {
+	const rows = 100
+	rng := rand.New(rand.NewSource(1))
+	counts := func(p *seedPicker, picks int) []int {
+		c := make([]int, rows)
+		for i := 0; i < picks; i++ {
+			c[p.pick(rng, rows)]++
+		}
+		return c
+	}
+
+	// Zipfian favours the first rows
+	zipf := counts(newSeedPicker(&SeedDistributionConfig{Type: distributionZipfian}, "", 0), 10000)
+	if zipf[0] <= zipf[1] || zipf[1] <= zipf[50] {
+		t.Errorf("Expected zipfian picks to fall with the row index, got %d, %d, %d", zipf[0], zipf[1], zipf[50])
+	}
+
+	// Hotspot sends the default 80% of picks to the first 20% of rows
+	hotspot := counts(newSeedPicker(&SeedDistributionConfig{Type: distributionHotspot}, "", 0), 10000)
+	hot := 0
+	for _, c := range hotspot[:20] {
+		hot += c
+	}
+	if hot < 7500 || hot > 8500 {
+		t.Errorf("Expected about 8000 hot picks, got %d", hot)
+	}
+
+	// An explicit share of 0 isn't replaced by the default, no picks go to the hot rows
+	none := 0.0
+	cold := counts(newSeedPicker(&SeedDistributionConfig{Type: distributionHotspot, HotTraffic: &none}, "", 0), 1000)
+	for i, c := range cold[:20] {
+		if c != 0 {
+			t.Errorf("Expected no picks of hot row %d, got %d", i, c)
+		}
+	}
+
+	// The Zipf generator of a stream is reused until the seed set changes size
+	picker := newSeedPicker(&SeedDistributionConfig{Type: distributionZipfian}, "", 0)
+	picker.pick(rng, rows)
+	z := picker.zipfs[rng]
+	picker.pick(rng, rows)
+	if picker.zipfs[rng] != z {
+		t.Errorf("Expected the Zipf generator to be reused")
+	}
+	if picker.pick(rng, 10) >= 10 || picker.zipfs[rng] == z {
+		t.Errorf("Expected a new Zipf generator for a new seed set size")
+	}
+
+	// Sequential pickers with the same key share their position
+	first := newSeedPicker(&SeedDistributionConfig{Type: distributionSequential}, t.Name(), 0)
+	second := newSeedPicker(&SeedDistributionConfig{Type: distributionSequential}, t.Name(), 1)
+	for i, p := range []*seedPicker{first, second, first, second} {
+		if row := p.pick(rng, rows); row != i {
+			t.Errorf("Expected sequential row %d, got %d", i, row)
+		}
+	}
+
+	// Round robin pickers start at their worker's row and wrap around
+	roundRobin := newSeedPicker(&SeedDistributionConfig{Type: distributionRoundRobin}, "", 99)
+	if a, b := roundRobin.pick(rng, rows), roundRobin.pick(rng, rows); a != 99 || b != 0 {
+		t.Errorf("Expected round robin rows 99 and 0, got %d and %d", a, b)
+	}
+
+	// Without a distribution every row is picked
+	var uniform *seedPicker
+	if c := counts(uniform, 10000); c[0] == 0 || c[rows-1] == 0 {
+		t.Errorf("Expected uniform picks across all rows")
+	}
+}
+
+func TestNewWorkerRandSeed(t *testing.T) {
+	a, b := newWorkerRand(42, 3, 1), newWorkerRand(42, 3, 1)
+	for i := 0; i < 10; i++ {
+		if x, y := a.Uint64(), b.Uint64(); x != y {
+			t.Fatalf("Expected seeded streams to repeat, got %d and %d", x, y)
+		}
+	}
+	if newWorkerRand(42, 3, 1).Uint64() == newWorkerRand(42, 4, 1).Uint64() {
+		t.Errorf("Expected workers to get different streams")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Phases of a query that can fail
+const (
+	phaseConnect  = "connect"
+	phasePrepare  = "prepare"
+	phaseExecute  = "execute"
+	phaseRows     = "rows"
+	phaseValidate = "validate" // The statement succeeded but its result didn't meet the expectation
+)
+
+// phaseError records which phase of a query failed
+type phaseError struct {
+	phase string
+	err   error
+}
+
+func (e *phaseError) Error() string {
+	return fmt.Sprintf("%s: %v", e.phase, e.err)
+}
+
+func (e *phaseError) Unwrap() error {
+	return e.err
+}
+
+// errorClass is the breakdown of a query error used in metrics and the run summary
+type errorClass struct {
+	Phase    string
+	Code     string // MySQL error number, or a name for driver and network errors
+	SQLState string
+}
+
+func (c errorClass) String() string {
+	if c.SQLState != "" {
+		return fmt.Sprintf("%s/%s/%s", c.Phase, c.Code, c.SQLState)
+	}
+	return fmt.Sprintf("%s/%s", c.Phase, c.Code)
+}
+
+// MySQL server errors returned while a connection is being established
+var connectErrorNumbers = map[uint16]bool{
+	1040: true, // Too many connections
+	1045: true, // Access denied
+	1129: true, // Host blocked because of many connection errors
+	1203: true, // User has exceeded max_user_connections
+}
+
+// classifyError splits an error into its phase, MySQL error number or driver/network error name,
+// and SQLSTATE. Errors without a recorded phase are counted as execute errors.
+func classifyError(err error) errorClass {
+	class := errorClass{Phase: phaseExecute, Code: "other"}
+
+	var pe *phaseError
+	if errors.As(err, &pe) {
+		class.Phase = pe.phase
+	}
+
+	var mismatch *resultMismatch
+	var mysqlErr *mysql.MySQLError
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &mismatch):
+		class.Code = mismatch.check
+	case errors.As(err, &mysqlErr):
+		class.Code = strconv.Itoa(int(mysqlErr.Number))
+		class.SQLState = strings.TrimRight(string(mysqlErr.SQLState[:]), "\x00")
+	case errors.Is(err, driver.ErrBadConn):
+		class.Code = "bad_conn"
+	case errors.Is(err, mysql.ErrInvalidConn):
+		class.Code = "invalid_conn"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		class.Code = "timeout"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		class.Code = "eof"
+	case errors.Is(err, syscall.ECONNRESET):
+		class.Code = "connection_reset"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		class.Code = "connection_refused"
+	case errors.As(err, &dnsErr):
+		class.Code = "dns"
+	case errors.As(err, &opErr):
+		class.Code = "network"
+	}
+	return class
+}
+
+// isConnectError reports whether err means a new connection couldn't be established
+func isConnectError(err error) bool {
+	var pe *phaseError
+	if errors.As(err, &pe) && pe.phase == phaseConnect {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return connectErrorNumbers[mysqlErr.Number]
+	}
+	return false
+}
+
+// recordQueryError counts a failed query by its error class and returns the class
+func recordQueryError(target string, workerID int, query string, err error) errorClass {
+	class := classifyError(err)
+	queryErrors.WithLabelValues(target, strconv.Itoa(workerID), query, class.Phase, class.Code, class.SQLState).Inc()
+	return class
+}
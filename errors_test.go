@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClassifyError(t *testing.T) {
+	tooMany := &mysql.MySQLError{Number: 1040, SQLState: [5]byte{'0', '8', '0', '0', '4'}, Message: "Too many connections"}
+	lockWait := &mysql.MySQLError{Number: 1205, SQLState: [5]byte{'H', 'Y', '0', '0', '0'}, Message: "Lock wait timeout exceeded"}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		err      error
+		expected string
+		connect  bool
+	}{
+		{&phaseError{phase: phaseConnect, err: tooMany}, "connect/1040/08004", true},
+		{&phaseError{phase: phaseExecute, err: lockWait}, "execute/1205/HY000", false},
+		{&phaseError{phase: phaseRows, err: mysql.ErrInvalidConn}, "rows/invalid_conn", false},
+		{&phaseError{phase: phaseExecute, err: driver.ErrBadConn}, "execute/bad_conn", false},
+		{&phaseError{phase: phaseConnect, err: refused}, "connect/connection_refused", true},
+		{&phaseError{phase: phaseRows, err: io.ErrUnexpectedEOF}, "rows/eof", false},
+		{fmt.Errorf("wrapped: %w", context.DeadlineExceeded), "execute/timeout", false},
+		{&phaseError{phase: phaseValidate, err: &resultMismatch{check: checkChecksum}}, "validate/checksum", false},
+		{errors.New("something else"), "execute/other", false},
+	}
+	for _, tt := range tests {
+		if class := classifyError(tt.err).String(); class != tt.expected {
+			t.Errorf("Expected %v to be classified as %s, got %s", tt.err, tt.expected, class)
+		}
+		if connect := isConnectError(tt.err); connect != tt.connect {
+			t.Errorf("Expected isConnectError(%v) to be %v", tt.err, tt.connect)
+		}
+	}
+}
+
+func TestGenericQueryErrorPhase(t *testing.T) {
+	resetMetrics()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	// A failed statement is an execute error
+	mock.ExpectQuery("SELECT \\* FROM users").WillReturnError(&mysql.MySQLError{Number: 1146, Message: "Table doesn't exist"})
+	_, _, err = genericQuery(&Target{DB: sqlxDB}, "SELECT * FROM users", nil)
+	if class := recordQueryError("primary", 1, "lookup", err); class.String() != "execute/1146" {
+		t.Errorf("Expected execute/1146, got %s", class)
+	}
+
+	// A connection lost while reading rows is a row iteration error
+	mock.ExpectQuery("SELECT id FROM users").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).RowError(1, mysql.ErrInvalidConn))
+	_, _, err = genericQuery(&Target{DB: sqlxDB}, "SELECT id FROM users", nil)
+	if class := recordQueryError("primary", 1, "lookup", err); class.String() != "rows/invalid_conn" {
+		t.Errorf("Expected rows/invalid_conn, got %s", class)
+	}
+
+	// A statement with parameters is prepared first, unless the driver interpolates them
+	mock.ExpectPrepare("SELECT name FROM users").WillReturnError(&mysql.MySQLError{Number: 1054, Message: "Unknown column"})
+	_, _, err = genericQuery(&Target{DB: sqlxDB}, "SELECT name FROM users WHERE id = ?", []interface{}{1})
+	if class := recordQueryError("primary", 1, "lookup", err); class.String() != "prepare/1054" {
+		t.Errorf("Expected prepare/1054, got %s", class)
+	}
+	interpolated := &Target{DB: sqlxDB, Config: &DatabaseConfig{DSN: "root@tcp(127.0.0.1:3306)/test?interpolateParams=true"}}
+	mock.ExpectQuery("SELECT name FROM users").WithArgs(1).WillReturnError(&mysql.MySQLError{Number: 1054, Message: "Unknown column"})
+	_, _, err = genericQuery(interpolated, "SELECT name FROM users WHERE id = ?", []interface{}{1})
+	if class := recordQueryError("primary", 1, "lookup", err); class.String() != "execute/1054" {
+		t.Errorf("Expected execute/1054 with interpolated parameters, got %s", class)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	if value := testutil.ToFloat64(queryErrors.WithLabelValues("primary", "1", "lookup", "execute", "1146", "")); value != 1 {
+		t.Errorf("Expected one execute/1146 error, got %v", value)
+	}
+	if value := testutil.ToFloat64(queryErrors.WithLabelValues("primary", "1", "lookup", "rows", "invalid_conn", "")); value != 1 {
+		t.Errorf("Expected one rows/invalid_conn error, got %v", value)
+	}
+}
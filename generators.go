@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+// valueGenerator produces the value of one statement parameter
+type valueGenerator func(rng *rand.Rand) interface{}
+
+// sequences are shared by every worker so "seq" values stay unique across the run
+var sequences sync.Map // map[string]*int64
+
+// loremWords is the vocabulary of lorem text
+var loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor
+	incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris
+	nisi aliquip ex ea commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum fugiat
+	nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa qui officia deserunt mollit anim id est laborum`)
+
+const alphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// maxGeneratedLength bounds the characters of a string and the words of lorem text
+const maxGeneratedLength = 1 << 20
+
+// parseGenerator builds a generator from a spec such as "seq", "int:1:1000" or "string:8:16".
+// Sequences are named by key so statements keep their own counter.
+//
+//	seq[:start]              increasing integers shared by all workers, from 1 by default
+//	int:min:max              random integer, both ends included
+//	float:min:max            random float
+//	string:min:max           random alphanumeric string of min to max characters
+//	uuid                     random version 4 UUID
+//	now                      the current time
+//	timestamp:range          random time within range before now, e.g. timestamp:24h
+//	lorem:min:max            min to max words of lorem ipsum
+//	choice:a|b|c             one of the listed values
+func parseGenerator(spec, key string) (valueGenerator, error) {
+	kind, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	var parts []string
+	if args != "" {
+		parts = strings.Split(args, ":")
+	}
+	ints := func(n int) ([]int64, error) {
+		if len(parts) != n {
+			return nil, fmt.Errorf("generator %q needs %d arguments", spec, n)
+		}
+		values := make([]int64, n)
+		for i, part := range parts {
+			v, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("generator %q: invalid number %q", spec, part)
+			}
+			values[i] = v
+		}
+		if n == 2 && values[0] > values[1] {
+			return nil, fmt.Errorf("generator %q: min is larger than max", spec)
+		}
+		return values, nil
+	}
+	// lengths parses the min and max length of strings and lorem text
+	lengths := func() ([]int64, error) {
+		values, err := ints(2)
+		if err != nil {
+			return nil, err
+		}
+		if values[0] < 0 || values[1] > maxGeneratedLength {
+			return nil, fmt.Errorf("generator %q: lengths must be between 0 and %d", spec, maxGeneratedLength)
+		}
+		return values, nil
+	}
+
+	switch kind {
+	case "seq":
+		start := int64(1)
+		if len(parts) > 0 {
+			values, err := ints(1)
+			if err != nil {
+				return nil, err
+			}
+			start = values[0]
+		}
+		counter, _ := sequences.LoadOrStore(key, new(int64))
+		next := counter.(*int64)
+		return func(*rand.Rand) interface{} {
+			return start + atomic.AddInt64(next, 1) - 1
+		}, nil
+	case "int":
+		values, err := ints(2)
+		if err != nil {
+			return nil, err
+		}
+		// The number of values in the range must fit in an int64
+		if span := values[1] - values[0]; span < 0 || span == math.MaxInt64 {
+			return nil, fmt.Errorf("generator %q: range is too wide", spec)
+		}
+		return func(rng *rand.Rand) interface{} {
+			return values[0] + rng.Int63n(values[1]-values[0]+1)
+		}, nil
+	case "float":
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("generator %q needs 2 arguments", spec)
+		}
+		min, errMin := strconv.ParseFloat(parts[0], 64)
+		max, errMax := strconv.ParseFloat(parts[1], 64)
+		if errMin != nil || errMax != nil || min > max || math.IsNaN(min) || math.IsNaN(max) || math.IsInf(max-min, 0) {
+			return nil, fmt.Errorf("generator %q: invalid range", spec)
+		}
+		return func(rng *rand.Rand) interface{} {
+			return min + rng.Float64()*(max-min)
+		}, nil
+	case "string":
+		values, err := lengths()
+		if err != nil {
+			return nil, err
+		}
+		return func(rng *rand.Rand) interface{} {
+			b := make([]byte, values[0]+rng.Int63n(values[1]-values[0]+1))
+			for i := range b {
+				b[i] = alphanumeric[rng.Intn(len(alphanumeric))]
+			}
+			return string(b)
+		}, nil
+	case "uuid":
+		return func(rng *rand.Rand) interface{} {
+			var b [16]byte
+			for i := range b {
+				b[i] = byte(rng.Intn(256))
+			}
+			b[6] = b[6]&0x0f | 0x40 // Version 4
+			b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+			return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+		}, nil
+	case "now":
+		return func(*rand.Rand) interface{} {
+			return time.Now()
+		}, nil
+	case "timestamp":
+		if len(parts) != 1 {
+			return nil, fmt.Errorf("generator %q needs a range such as timestamp:24h", spec)
+		}
+		window, err := time.ParseDuration(parts[0])
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("generator %q: invalid range %q", spec, parts[0])
+		}
+		return func(rng *rand.Rand) interface{} {
+			return time.Now().Add(-time.Duration(rng.Int63n(int64(window))))
+		}, nil
+	case "lorem":
+		values, err := lengths()
+		if err != nil {
+			return nil, err
+		}
+		return func(rng *rand.Rand) interface{} {
+			words := make([]string, values[0]+rng.Int63n(values[1]-values[0]+1))
+			for i := range words {
+				words[i] = loremWords[rng.Intn(len(loremWords))]
+			}
+			return strings.Join(words, " ")
+		}, nil
+	case "choice":
+		choices := strings.Split(args, "|")
+		if args == "" {
+			return nil, fmt.Errorf("generator %q has no choices", spec)
+		}
+		return func(rng *rand.Rand) interface{} {
+			return choices[rng.Intn(len(choices))]
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator %q", spec)
+	}
+}
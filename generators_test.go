@@ -0,0 +1,106 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/rand"
+)
+
+func TestParseGenerator(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	generate := func(spec string) interface{} {
+		t.Helper()
+		generator, err := parseGenerator(spec, t.Name()+"/"+spec)
+		if err != nil {
+			t.Fatalf("Failed to parse generator %q: %v", spec, err)
+		}
+		return generator(rng)
+	}
+
+	for i := 0; i < 100; i++ {
+		if v := generate("int:5:7").(int64); v < 5 || v > 7 {
+			t.Errorf("Expected int in [5, 7], got %d", v)
+		}
+		if v := generate("float:0.5:1.5").(float64); v < 0.5 || v > 1.5 {
+			t.Errorf("Expected float in [0.5, 1.5], got %f", v)
+		}
+		if v := generate("string:3:5").(string); len(v) < 3 || len(v) > 5 {
+			t.Errorf("Expected string of 3 to 5 characters, got %q", v)
+		}
+		if v := generate("lorem:2:4").(string); len(strings.Fields(v)) < 2 || len(strings.Fields(v)) > 4 {
+			t.Errorf("Expected 2 to 4 words, got %q", v)
+		}
+		if v := generate("choice:a|b").(string); v != "a" && v != "b" {
+			t.Errorf("Expected a or b, got %q", v)
+		}
+		if v := generate("timestamp:1h").(time.Time); time.Since(v) < 0 || time.Since(v) > time.Hour+time.Second {
+			t.Errorf("Expected a time within the last hour, got %v", v)
+		}
+	}
+
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if v := generate("uuid").(string); !uuid.MatchString(v) {
+		t.Errorf("Expected a version 4 UUID, got %q", v)
+	}
+
+	for _, spec := range []string{"", "bogus", "int", "int:1", "int:2:1", "int:a:b", "float:2:1", "string:1", "timestamp:soon", "choice", "seq:x"} {
+		if _, err := parseGenerator(spec, "invalid"); err == nil {
+			t.Errorf("Expected error for generator %q", spec)
+		}
+	}
+
+	// Bounds that would panic or exhaust memory while generating are rejected up front
+	for _, spec := range []string{
+		"int:-9223372036854775808:9223372036854775807", "int:0:9223372036854775807",
+		"string:-5:-1", "lorem:-1:3", "string:0:9999999999", "float:NaN:NaN", "float:-1e308:1e308",
+	} {
+		if _, err := parseGenerator(spec, "invalid"); err == nil {
+			t.Errorf("Expected error for generator %q", spec)
+		}
+	}
+	if v := generate("int:0:9223372036854775806").(int64); v < 0 {
+		t.Errorf("Expected a non-negative integer from the widest range, got %d", v)
+	}
+}
+
+func TestSequenceGenerator(t *testing.T) {
+	// Generators with the same key share one sequence, as the workers of a scenario do
+	first, err := parseGenerator("seq:100", t.Name())
+	if err != nil {
+		t.Fatalf("Failed to parse generator: %v", err)
+	}
+	second, err := parseGenerator("seq:100", t.Name())
+	if err != nil {
+		t.Fatalf("Failed to parse generator: %v", err)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int64]bool)
+	var wg sync.WaitGroup
+	for _, generator := range []valueGenerator{first, second} {
+		wg.Add(1)
+		go func(generator valueGenerator) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				v := generator(nil).(int64)
+				mu.Lock()
+				seen[v] = true
+				mu.Unlock()
+			}
+		}(generator)
+	}
+	wg.Wait()
+
+	if len(seen) != 100 {
+		t.Fatalf("Expected 100 unique values, got %d", len(seen))
+	}
+	for v := int64(100); v < 200; v++ {
+		if !seen[v] {
+			t.Errorf("Expected sequence value %d", v)
+		}
+	}
+}
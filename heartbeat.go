@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HeartbeatConfig writes a timestamped row to the primary target at a fixed interval
+// and reads it back on each replica target to measure replication lag, like pt-heartbeat
+type HeartbeatConfig struct {
+	Primary     string        `yaml:"primary"`      // Target the heartbeat is written to
+	Replicas    []string      `yaml:"replicas"`     // Targets the heartbeat is read from, every other target when empty
+	Table       string        `yaml:"table"`        // Defaults to heartbeat
+	ID          int           `yaml:"id"`           // Heartbeat row, lets several testers share the table. Defaults to 1
+	Interval    time.Duration `yaml:"interval"`     // Defaults to 1s
+	CreateTable bool          `yaml:"create_table"` // Create the table on the primary if it doesn't exist
+}
+
+// check rejects heartbeats that name unknown targets
+func (h *HeartbeatConfig) check(targets []DatabaseConfig) error {
+	names := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		names[t.Name] = true
+	}
+	if !names[h.Primary] {
+		return fmt.Errorf("heartbeat: unknown primary target %q", h.Primary)
+	}
+	for _, name := range h.Replicas {
+		if !names[name] {
+			return fmt.Errorf("heartbeat: unknown replica target %q", name)
+		}
+		if name == h.Primary {
+			return fmt.Errorf("heartbeat: target %q can't be both primary and replica", name)
+		}
+	}
+	return nil
+}
+
+// ReplicaLagSummary is the replication lag seen on one replica. Lag is in milliseconds: how far
+// the replica's heartbeat is behind the last one written, read half an interval after the write.
+type ReplicaLagSummary struct {
+	Target              string  `json:"target"`
+	Samples             int64   `json:"samples"`
+	Errors              int64   `json:"errors"`        // Failed heartbeat reads
+	StatusErrors        int64   `json:"status_errors"` // Failed replica status checks
+	Mean                float64 `json:"mean_lag_ms"`
+	P50                 float64 `json:"p50_lag_ms"`
+	P99                 float64 `json:"p99_lag_ms"`
+	Max                 float64 `json:"max_lag_ms"`
+	SecondsBehindSource float64 `json:"seconds_behind_source"` // Last reported, -1 when replication isn't running or unknown
+}
+
+// heartbeat writes and reads the heartbeat row of one run
+type heartbeat struct {
+	primary  *Target
+	replicas []*Target
+	table    string
+	id       int
+	interval time.Duration
+	written  time.Time // Last heartbeat written, only used by the beat loop
+
+	mu           sync.Mutex
+	lag          map[string]*latencyHistogram
+	behind       map[string]float64
+	statusErrors map[string]int64
+}
+
+// newHeartbeat resolves the primary and replica targets and fills in the defaults
+func newHeartbeat(cfg *HeartbeatConfig, targets []*Target) *heartbeat {
+	h := &heartbeat{
+		table:        cfg.Table,
+		id:           cfg.ID,
+		interval:     cfg.Interval,
+		lag:          make(map[string]*latencyHistogram),
+		behind:       make(map[string]float64),
+		statusErrors: make(map[string]int64),
+	}
+	if h.table == "" {
+		h.table = "heartbeat"
+	}
+	if h.id == 0 {
+		h.id = 1
+	}
+	if h.interval <= 0 {
+		h.interval = time.Second
+	}
+
+	replicas := make(map[string]bool)
+	for _, name := range cfg.Replicas {
+		replicas[name] = true
+	}
+	for _, t := range targets {
+		switch {
+		case t.Name == cfg.Primary:
+			h.primary = t
+		case len(replicas) == 0 || replicas[t.Name]:
+			h.replicas = append(h.replicas, t)
+		}
+	}
+	for _, t := range h.replicas {
+		h.lag[t.Name] = newLatencyHistogram()
+		h.behind[t.Name] = -1
+	}
+	return h
+}
+
+// RunHeartbeat writes the heartbeat and measures lag on every replica until ctx is cancelled
+func RunHeartbeat(ctx context.Context, cfg *HeartbeatConfig, targets []*Target) []ReplicaLagSummary {
+	h := newHeartbeat(cfg, targets)
+	if cfg.CreateTable {
+		if err := h.createTable(ctx); err != nil {
+			log.Printf("Heartbeat failed to create table %s on %s: %v", h.table, h.primary.Name, err)
+			heartbeatErrors.WithLabelValues(h.primary.Name, "create").Inc()
+			return nil
+		}
+	}
+
+	log.Printf("Starting heartbeat on %s every %v, read from %d replicas", h.primary.Name, h.interval, len(h.replicas))
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.beat(ctx)
+		select {
+		case <-ctx.Done():
+			return h.summary()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *heartbeat) createTable(ctx context.Context) error {
+	_, err := h.primary.DB.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id INT NOT NULL PRIMARY KEY, ts BIGINT NOT NULL)", h.table))
+	return err
+}
+
+// beat writes the current time on the primary, then reads the heartbeat and replication status on every replica.
+// Like pt-heartbeat's skew, the replicas are read half an interval after the write, so a replica
+// that keeps up has applied it by then instead of still showing the previous beat.
+func (h *heartbeat) beat(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, h.interval)
+	defer cancel()
+
+	// Timestamps come from this process's clock on both ends, so server clock skew doesn't matter
+	now := time.Now().Truncate(time.Microsecond)
+	_, err := h.primary.DB.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (id, ts) VALUES (?, ?) ON DUPLICATE KEY UPDATE ts = VALUES(ts)", h.table),
+		h.id, now.UnixMicro())
+	switch {
+	case err == nil:
+		h.written = now
+	case ctx.Err() == nil:
+		heartbeatErrors.WithLabelValues(h.primary.Name, "write").Inc()
+		log.Printf("Heartbeat write to %s failed: %v", h.primary.Name, err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(h.interval / 2):
+	}
+
+	var wg sync.WaitGroup
+	for _, replica := range h.replicas {
+		wg.Add(1)
+		go func(replica *Target) {
+			defer wg.Done()
+			h.readLag(ctx, replica, h.written)
+			h.readReplicaStatus(ctx, replica)
+		}(replica)
+	}
+	wg.Wait()
+}
+
+// readLag reads the heartbeat row on a replica and records how far it is behind the last one written,
+// or how old it is before any was written
+func (h *heartbeat) readLag(ctx context.Context, replica *Target, written time.Time) {
+	var ts int64
+	err := replica.DB.QueryRowxContext(ctx, fmt.Sprintf("SELECT ts FROM %s WHERE id = ?", h.table), h.id).Scan(&ts)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The first heartbeat hasn't replicated yet
+		return
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			h.recordError(replica.Name, "read")
+			log.Printf("Heartbeat read from %s failed: %v", replica.Name, err)
+		}
+		return
+	}
+
+	if written.IsZero() {
+		written = time.Now()
+	}
+	lag := written.Sub(time.UnixMicro(ts))
+	if lag < 0 {
+		lag = 0
+	}
+	replicationLag.WithLabelValues(replica.Name).Set(lag.Seconds())
+	replicationLagDuration.WithLabelValues(replica.Name).Observe(lag.Seconds())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lag[replica.Name].observe(lag)
+}
+
+// readReplicaStatus records Seconds_Behind_Source, or Seconds_Behind_Master on servers before 8.0.22.
+// Failed checks are counted apart from the heartbeat reads, they don't say the lag is unknown.
+func (h *heartbeat) readReplicaStatus(ctx context.Context, replica *Target) {
+	_, rows, err := runStatement(ctx, replica.DB, "SHOW REPLICA STATUS", nil)
+	if err != nil && ctx.Err() == nil {
+		_, rows, err = runStatement(ctx, replica.DB, "SHOW SLAVE STATUS", nil)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			heartbeatErrors.WithLabelValues(replica.Name, "status").Inc()
+			log.Printf("Heartbeat replica status of %s failed: %v", replica.Name, err)
+			h.mu.Lock()
+			h.statusErrors[replica.Name]++
+			h.mu.Unlock()
+		}
+		return
+	}
+
+	behind := -1.0
+	if len(rows) > 0 {
+		for _, column := range []string{"Seconds_Behind_Source", "Seconds_Behind_Master"} {
+			value, ok := rows[0][column]
+			if !ok || value == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(fmt.Sprint(value), 64); err == nil {
+				behind = seconds
+			}
+			break
+		}
+	}
+	secondsBehindSource.WithLabelValues(replica.Name).Set(behind)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.behind[replica.Name] = behind
+}
+
+func (h *heartbeat) recordError(target, operation string) {
+	heartbeatErrors.WithLabelValues(target, operation).Inc()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lag[target].errors++
+}
+
+// summary reports the lag of every replica in target order
+func (h *heartbeat) summary() []ReplicaLagSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	result := make([]ReplicaLagSummary, 0, len(h.replicas))
+	for _, replica := range h.replicas {
+		lag := h.lag[replica.Name]
+		summary := ReplicaLagSummary{
+			Target:              replica.Name,
+			Samples:             lag.count,
+			Errors:              lag.errors,
+			StatusErrors:        h.statusErrors[replica.Name],
+			P50:                 ms(lag.percentile(0.50)),
+			P99:                 ms(lag.percentile(0.99)),
+			Max:                 ms(lag.max),
+			SecondsBehindSource: h.behind[replica.Name],
+		}
+		if lag.count > 0 {
+			summary.Mean = ms(lag.sum / time.Duration(lag.count))
+		}
+		result = append(result, summary)
+	}
+	return result
+}
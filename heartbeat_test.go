@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHeartbeatBeat(t *testing.T) {
+	resetMetrics()
+
+	primaryDB, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer primaryDB.Close()
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer replicaDB.Close()
+
+	targets := []*Target{
+		{Name: "primary", DB: sqlx.NewDb(primaryDB, "mysql")},
+		{Name: "replica", DB: sqlx.NewDb(replicaDB, "mysql")},
+	}
+	h := newHeartbeat(&HeartbeatConfig{Primary: "primary", Interval: time.Second}, targets)
+	if len(h.replicas) != 1 || h.replicas[0].Name != "replica" || h.table != "heartbeat" || h.id != 1 {
+		t.Fatalf("Unexpected heartbeat: %+v", h)
+	}
+
+	// The replica serves a heartbeat written two seconds ago
+	primaryMock.ExpectExec("INSERT INTO heartbeat").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	replicaMock.ExpectQuery("SELECT ts FROM heartbeat").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"ts"}).AddRow(time.Now().Add(-2 * time.Second).UnixMicro()))
+	replicaMock.ExpectQuery("SHOW REPLICA STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}).AddRow("2"))
+	h.beat(context.Background())
+
+	if err := primaryMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet primary expectations: %v", err)
+	}
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet replica expectations: %v", err)
+	}
+	if lag := testutil.ToFloat64(replicationLag.WithLabelValues("replica")); lag < 2 || lag > 3 {
+		t.Errorf("Expected about 2s of lag, got %v", lag)
+	}
+
+	summary := h.summary()
+	if len(summary) != 1 || summary[0].Samples != 1 || summary[0].SecondsBehindSource != 2 {
+		t.Errorf("Unexpected replica summary: %+v", summary)
+	}
+	if summary[0].Max < 2000 {
+		t.Errorf("Expected max lag of at least 2000ms, got %v", summary[0].Max)
+	}
+}
+
+func TestHeartbeatCurrentBeat(t *testing.T) {
+	resetMetrics()
+
+	primaryDB, primaryMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer primaryDB.Close()
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer replicaDB.Close()
+
+	targets := []*Target{
+		{Name: "primary", DB: sqlx.NewDb(primaryDB, "mysql")},
+		{Name: "replica", DB: sqlx.NewDb(replicaDB, "mysql")},
+	}
+	h := newHeartbeat(&HeartbeatConfig{Primary: "primary", Interval: 100 * time.Millisecond}, targets)
+
+	// The replica has already applied the beat written this interval, it isn't behind
+	primaryMock.ExpectExec("INSERT INTO heartbeat").WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	replicaMock.ExpectQuery("SELECT ts FROM heartbeat").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"ts"}).AddRow(time.Now().UnixMicro()))
+	replicaMock.ExpectQuery("SHOW REPLICA STATUS").
+		WillReturnRows(sqlmock.NewRows([]string{"Seconds_Behind_Source"}).AddRow("0"))
+	start := time.Now()
+	h.beat(context.Background())
+
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the replica to be read half an interval after the write, read after %v", elapsed)
+	}
+	if err := replicaMock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet replica expectations: %v", err)
+	}
+	if lag := testutil.ToFloat64(replicationLag.WithLabelValues("replica")); lag > 0.01 {
+		t.Errorf("Expected about no lag, got %vs", lag)
+	}
+}
+
+func TestHeartbeatCheck(t *testing.T) {
+	targets := []DatabaseConfig{{Name: "primary"}, {Name: "replica"}}
+	if err := (&HeartbeatConfig{Primary: "primary", Replicas: []string{"replica"}}).check(targets); err != nil {
+		t.Errorf("Expected valid heartbeat, got %v", err)
+	}
+	if err := (&HeartbeatConfig{Primary: "writer"}).check(targets); err == nil {
+		t.Errorf("Expected error for an unknown primary")
+	}
+	if err := (&HeartbeatConfig{Primary: "primary", Replicas: []string{"primary"}}).check(targets); err == nil {
+		t.Errorf("Expected error for a primary listed as replica")
+	}
+}
+
+func TestHeartbeatStatusErrors(t *testing.T) {
+	resetMetrics()
+
+	replicaDB, replicaMock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer replicaDB.Close()
+
+	replica := &Target{Name: "replica", DB: sqlx.NewDb(replicaDB, "mysql")}
+	h := newHeartbeat(&HeartbeatConfig{Primary: "primary", Interval: time.Second}, []*Target{{Name: "primary"}, replica})
+
+	// Neither status statement works, which says nothing about the heartbeat lag
+	replicaMock.ExpectQuery("SHOW REPLICA STATUS").WillReturnError(fmt.Errorf("access denied"))
+	replicaMock.ExpectQuery("SHOW SLAVE STATUS").WillReturnError(fmt.Errorf("access denied"))
+	h.readReplicaStatus(context.Background(), replica)
+
+	summary := h.summary()
+	if len(summary) != 1 || summary[0].StatusErrors != 1 || summary[0].Errors != 0 || summary[0].SecondsBehindSource != -1 {
+		t.Errorf("Unexpected replica summary: %+v", summary)
+	}
+	if value := testutil.ToFloat64(heartbeatErrors.WithLabelValues("replica", "status")); value != 1 {
+		t.Errorf("Expected one status error, got %v", value)
+	}
+
+	// A status check that outlives the beat is abandoned, not counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.readReplicaStatus(ctx, replica)
+	if summary := h.summary(); summary[0].StatusErrors != 1 {
+		t.Errorf("Expected a cancelled check not to count, got %d status errors", summary[0].StatusErrors)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// identityQuery asks the server which backend and thread serve the connection
+const identityQuery = "SELECT CONNECTION_ID(), @@hostname, @@server_id"
+
+// backendIdentity is what the server reports about the connection that served a query
+type backendIdentity struct {
+	ConnectionID int64
+	Hostname     string
+	ServerID     int64
+}
+
+// identityRetention is how long an unused connection is kept when its pool never closes idle connections
+const identityRetention = 10 * time.Minute
+
+// identitySweep is how often connections that were closed or replaced are retired
+const identitySweep = time.Second
+
+// identityKey is a backend connection of a target. Backends hand out the same connection IDs,
+// so the connection is only known by its ID together with the backend.
+type identityKey struct {
+	target   string
+	identity backendIdentity
+}
+
+// connIdentity follows the queries served by one backend connection
+type connIdentity struct {
+	id        int // Order in which the connection was first seen
+	scenario  string
+	queries   int64
+	clients   map[int]bool // Client connections whose queries it served
+	lastSeen  time.Time
+	retention time.Duration // Unused for longer than this the pool has closed it
+}
+
+// clientKey is a connection of a target's pool, known by its driver connection
+type clientKey struct {
+	target string
+	conn   interface{}
+}
+
+// clientConn follows the backend connection that served the last query of a client connection
+type clientConn struct {
+	id        int
+	backend   backendIdentity
+	lastSeen  time.Time
+	retention time.Duration
+}
+
+// identityTracker records the backend identity after every query, so load balancing
+// and connection multiplexing in proxies show up per backend connection.
+// Connections that were closed or replaced are retired into the backend totals.
+type identityTracker struct {
+	mu       sync.Mutex
+	conns    map[identityKey]*connIdentity
+	clients  map[clientKey]*clientConn
+	seen     int // Backend connections seen so far, retired ones included
+	clientID int
+	switches int64
+	swept    time.Time
+	retired  map[backendIdentity]*BackendSummary // Keyed without the connection ID
+}
+
+// identities is the shared tracker fed by every scenario with track_identity enabled
+var identities = newIdentityTracker()
+
+func newIdentityTracker() *identityTracker {
+	return &identityTracker{
+		conns:   make(map[identityKey]*connIdentity),
+		clients: make(map[clientKey]*clientConn),
+		retired: make(map[backendIdentity]*BackendSummary),
+	}
+}
+
+// check returns a connection check that looks up the identity of the backend that served each statement.
+// Connections unused for longer than the pool keeps idle connections are taken to be closed.
+func (t *identityTracker) check(target *Target, scenario string) connectionCheck {
+	retention := target.Config.ConnIdleTimeout
+	if retention <= 0 {
+		retention = target.Config.ConnMaxLifetime
+	}
+	if retention <= 0 {
+		retention = identityRetention
+	}
+	return func(ctx context.Context, conn *sqlx.Conn, runner sqlx.QueryerContext, _ *WeightedQuery, err error) {
+		if err != nil {
+			return
+		}
+		// A failed lookup doesn't fail the query, the next one tries again
+		var identity backendIdentity
+		if err := runner.QueryRowxContext(ctx, identityQuery).Scan(&identity.ConnectionID, &identity.Hostname, &identity.ServerID); err != nil {
+			identityErrors.WithLabelValues(target.Name, scenario).Inc()
+			return
+		}
+		var client interface{}
+		conn.Raw(func(driverConn interface{}) error {
+			client = driverConn
+			return nil
+		})
+		t.record(target.Name, scenario, client, identity, retention, time.Now())
+	}
+}
+
+// record adds a query that a client connection sent and a backend connection served.
+// A client connection served by another backend connection than before counts as a switch.
+func (t *identityTracker) record(target, scenario string, client interface{}, identity backendIdentity, retention time.Duration, now time.Time) {
+	backendQueries.WithLabelValues(target, scenario, identity.Hostname, strconv.FormatInt(identity.ServerID, 10)).Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now.Sub(t.swept) >= identitySweep {
+		t.sweep(now)
+	}
+
+	ck := clientKey{target: target, conn: client}
+	cl, ok := t.clients[ck]
+	if !ok {
+		t.clientID++
+		cl = &clientConn{id: t.clientID, backend: identity}
+		t.clients[ck] = cl
+	} else if cl.backend != identity {
+		t.switches++
+		backendSwitches.WithLabelValues(target, scenario).Inc()
+		cl.backend = identity
+	}
+	cl.lastSeen = now
+	cl.retention = retention
+
+	key := identityKey{target: target, identity: identity}
+	c, ok := t.conns[key]
+	if !ok {
+		t.seen++
+		c = &connIdentity{id: t.seen, scenario: scenario, clients: make(map[int]bool)}
+		t.conns[key] = c
+	}
+	c.queries++
+	c.clients[cl.id] = true
+	c.lastSeen = now
+	c.retention = retention
+}
+
+// sweep retires the connections that weren't used for longer than their pool keeps them
+func (t *identityTracker) sweep(now time.Time) {
+	t.swept = now
+	for key, c := range t.conns {
+		if now.Sub(c.lastSeen) > c.retention {
+			t.retire(key, c)
+		}
+	}
+	for key, cl := range t.clients {
+		if now.Sub(cl.lastSeen) > cl.retention {
+			delete(t.clients, key)
+		}
+	}
+}
+
+// retire folds a connection into the totals of its backend and forgets it
+func (t *identityTracker) retire(key identityKey, c *connIdentity) {
+	delete(t.conns, key)
+	backend := backendIdentity{Hostname: key.identity.Hostname, ServerID: key.identity.ServerID}
+	b, ok := t.retired[backend]
+	if !ok {
+		b = &BackendSummary{Hostname: backend.Hostname, ServerID: backend.ServerID}
+		t.retired[backend] = b
+	}
+	b.Queries += c.queries
+	b.Connections++
+}
+
+// BackendSummary is the share of queries one backend served
+type BackendSummary struct {
+	Hostname    string `json:"hostname"`
+	ServerID    int64  `json:"server_id"`
+	Queries     int64  `json:"queries"`
+	Connections int    `json:"connections"` // Backend connections that served the queries
+}
+
+// ConnectionSummary is the history of one backend connection still open at the end of the run
+type ConnectionSummary struct {
+	Connection   int    `json:"connection"`
+	Target       string `json:"target"`
+	Scenario     string `json:"scenario"`
+	Queries      int64  `json:"queries"`
+	Clients      int    `json:"clients"` // Client connections it served, more than one when a proxy multiplexes
+	ConnectionID int64  `json:"connection_id"`
+	Hostname     string `json:"hostname"`
+	ServerID     int64  `json:"server_id"`
+}
+
+// IdentitySummary is the backend distribution and per-connection table of the run
+type IdentitySummary struct {
+	Backends           []BackendSummary    `json:"backends"`
+	Connections        []ConnectionSummary `json:"connections"`
+	RetiredConnections int                 `json:"retired_connections"` // Closed or replaced, counted in the backends only
+	Switches           int64               `json:"switches"`            // Queries of a client connection served by another backend connection than its last one
+}
+
+// Summary builds the report, or returns nil when no identities were recorded
+func (t *identityTracker) Summary() *IdentitySummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == 0 {
+		return nil
+	}
+
+	summary := &IdentitySummary{Switches: t.switches}
+	backends := make(map[backendIdentity]*BackendSummary)
+	for backend, retired := range t.retired {
+		b := *retired
+		backends[backend] = &b
+		summary.RetiredConnections += b.Connections
+	}
+	for key, c := range t.conns {
+		summary.Connections = append(summary.Connections, ConnectionSummary{
+			Connection:   c.id,
+			Target:       key.target,
+			Scenario:     c.scenario,
+			Queries:      c.queries,
+			Clients:      len(c.clients),
+			ConnectionID: key.identity.ConnectionID,
+			Hostname:     key.identity.Hostname,
+			ServerID:     key.identity.ServerID,
+		})
+
+		backend := backendIdentity{Hostname: key.identity.Hostname, ServerID: key.identity.ServerID}
+		b, ok := backends[backend]
+		if !ok {
+			b = &BackendSummary{Hostname: backend.Hostname, ServerID: backend.ServerID}
+			backends[backend] = b
+		}
+		b.Queries += c.queries
+		b.Connections++
+	}
+	for _, b := range backends {
+		summary.Backends = append(summary.Backends, *b)
+	}
+	sort.Slice(summary.Backends, func(i, j int) bool {
+		if summary.Backends[i].Hostname != summary.Backends[j].Hostname {
+			return summary.Backends[i].Hostname < summary.Backends[j].Hostname
+		}
+		return summary.Backends[i].ServerID < summary.Backends[j].ServerID
+	})
+	sort.Slice(summary.Connections, func(i, j int) bool {
+		return summary.Connections[i].Connection < summary.Connections[j].Connection
+	})
+	return summary
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIdentityTracker(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	// A proxy multiplexing the client connection onto two backend connections.
+	// The slow lookups must not count towards the statement latency.
+	identityColumns := []string{"CONNECTION_ID()", "@@hostname", "@@server_id"}
+	for _, identity := range []backendIdentity{{11, "db1", 1}, {11, "db1", 1}, {27, "db2", 2}} {
+		mock.ExpectQuery("SELECT id FROM users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT CONNECTION_ID\\(\\)").
+			WillDelayFor(100 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows(identityColumns).AddRow(identity.ConnectionID, identity.Hostname, identity.ServerID))
+	}
+
+	tracker := newIdentityTracker()
+	target := &Target{Name: "primary", Config: &DatabaseConfig{}, DB: sqlxDB}
+	run := &scenarioRun{target: "primary", db: sqlxDB, check: tracker.check(target, "lookup")}
+	query := &WeightedQuery{Name: "users", SQL: "SELECT id FROM users"}
+	for i := 0; i < 3; i++ {
+		runWorkloadQuery(run, 0, 0, query, nil, time.Now())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+	if h := runStats.byQuery["users"]; h == nil || h.count != 3 || h.max >= 100*time.Millisecond {
+		t.Errorf("Expected three statements timed without their lookups, got %+v", h)
+	}
+
+	summary := tracker.Summary()
+	if len(summary.Backends) != 2 || summary.Backends[0].Queries != 2 || summary.Backends[1].Queries != 1 {
+		t.Errorf("Unexpected backend distribution: %+v", summary.Backends)
+	}
+	if len(summary.Connections) != 2 {
+		t.Fatalf("Expected two backend connections, got %+v", summary.Connections)
+	}
+	if summary.Switches != 1 {
+		t.Errorf("Expected the pooled connection to switch backends once, got %d", summary.Switches)
+	}
+	if c := summary.Connections[0]; c.Queries != 2 || c.Clients != 1 || c.ConnectionID != 11 || c.Hostname != "db1" {
+		t.Errorf("Unexpected connection summary: %+v", c)
+	}
+	if c := summary.Connections[1]; c.Queries != 1 || c.ConnectionID != 27 || c.Hostname != "db2" {
+		t.Errorf("Unexpected connection summary: %+v", c)
+	}
+	if value := testutil.ToFloat64(backendQueries.WithLabelValues("primary", "lookup", "db1", "1")); value != 2 {
+		t.Errorf("Expected two queries on db1, got %v", value)
+	}
+
+	// Nothing to report without tracked queries
+	if newIdentityTracker().Summary() != nil {
+		t.Errorf("Expected no summary from an empty tracker")
+	}
+}
+
+func TestIdentityTrackerRetiresConnections(t *testing.T) {
+	resetMetrics()
+
+	tracker := newIdentityTracker()
+	start := time.Now()
+	first, second, third := new(int), new(int), new(int)
+
+	// Two backends hand out the same connection ID to two live connections, which is no switch
+	tracker.record("primary", "lookup", first, backendIdentity{5, "db1", 1}, time.Minute, start)
+	tracker.record("primary", "lookup", second, backendIdentity{5, "db2", 2}, time.Minute, start.Add(time.Second))
+	if value := testutil.ToFloat64(backendSwitches.WithLabelValues("primary", "lookup")); value != 0 {
+		t.Errorf("Expected no backend switch, got %v", value)
+	}
+
+	// A proxy sends the first client connection's next query to the second one's backend connection
+	tracker.record("primary", "lookup", first, backendIdentity{5, "db2", 2}, time.Minute, start.Add(2*time.Second))
+	if value := testutil.ToFloat64(backendSwitches.WithLabelValues("primary", "lookup")); value != 1 {
+		t.Errorf("Expected one backend switch, got %v", value)
+	}
+	summary := tracker.Summary()
+	if summary.Switches != 1 || len(summary.Connections) != 2 || summary.Connections[1].Clients != 2 {
+		t.Errorf("Expected the db2 connection to serve both clients, got %+v", summary)
+	}
+
+	// Unused for longer than the pool keeps idle connections, the first two were closed
+	tracker.record("primary", "lookup", third, backendIdentity{6, "db2", 2}, time.Minute, start.Add(3*time.Minute))
+	if len(tracker.conns) != 1 || len(tracker.clients) != 1 {
+		t.Errorf("Expected only the last connection to be kept, got %d backend and %d client connections", len(tracker.conns), len(tracker.clients))
+	}
+
+	summary = tracker.Summary()
+	if summary.RetiredConnections != 2 || len(summary.Connections) != 1 || summary.Connections[0].ConnectionID != 6 {
+		t.Errorf("Unexpected connections: %+v, %d retired", summary.Connections, summary.RetiredConnections)
+	}
+	if len(summary.Backends) != 2 || summary.Backends[0].Connections != 1 || summary.Backends[1].Queries != 3 || summary.Backends[1].Connections != 2 {
+		t.Errorf("Unexpected backend distribution: %+v", summary.Backends)
+	}
+}
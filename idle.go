@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IdleTestConfig holds connections idle for each interval and then probes them,
+// to find the idle cutoff imposed by wait_timeout, NAT gateways or load balancers
+type IdleTestConfig struct {
+	Connections  int             `yaml:"connections"`   // Connections held idle per interval
+	Intervals    []time.Duration `yaml:"intervals"`     // Idle durations to test, run side by side
+	ProbeQuery   string          `yaml:"probe_query"`   // Defaults to SELECT 1
+	ProbeTimeout time.Duration   `yaml:"probe_timeout"` // Defaults to 10s, a silently dropped connection can hang
+}
+
+// idleResult is the outcome of probing the connections held idle for one interval
+type idleResult struct {
+	interval time.Duration
+	alive    int
+	dead     int
+	errors   map[string]int // Dead connections by error code
+}
+
+// IdleIntervalSummary is the result line of one idle interval
+type IdleIntervalSummary struct {
+	Interval string         `json:"interval"`
+	Alive    int            `json:"alive"`
+	Dead     int            `json:"dead"`
+	Errors   map[string]int `json:"errors,omitempty"`
+}
+
+// IdleSummary is the idle survival report. Durations are written the way the config takes them,
+// so the suggested conn_idle_timeout can be copied back into the database section.
+type IdleSummary struct {
+	Target           string                `json:"target"`
+	Intervals        []IdleIntervalSummary `json:"intervals"`
+	CutoffFound      bool                  `json:"cutoff_found"`
+	SuggestedTimeout string                `json:"suggested_conn_idle_timeout,omitempty"`
+}
+
+// RunIdleTest opens a dedicated pool to the target without idle or lifetime limits, so only the server
+// and the network in between can close the held connections, and probes every interval.
+// Intervals that didn't finish before ctx was cancelled are left out of the report.
+func RunIdleTest(ctx context.Context, target *Target, tc *IdleTestConfig) *IdleSummary {
+	connector, err := newTimedConnector(target.Name, target.Config.DSN)
+	if err != nil {
+		log.Printf("Idle test of target %s failed to start: %v", target.Name, err)
+		return nil
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	defer db.Close()
+
+	var mu sync.Mutex
+	var results []idleResult
+	var wg sync.WaitGroup
+	for _, interval := range tc.Intervals {
+		wg.Add(1)
+		go func(interval time.Duration) {
+			defer wg.Done()
+			result, ok := probeIdleInterval(ctx, db, target.Name, interval, tc)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(interval)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].interval < results[j].interval })
+	summary := summarizeIdle(results)
+	summary.Target = target.Name
+	return summary
+}
+
+// probeIdleInterval opens connections, leaves them idle for the interval and probes each of them
+func probeIdleInterval(ctx context.Context, db *sqlx.DB, target string, interval time.Duration, tc *IdleTestConfig) (idleResult, bool) {
+	probeQuery := tc.ProbeQuery
+	if probeQuery == "" {
+		probeQuery = "SELECT 1"
+	}
+	probeTimeout := tc.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = 10 * time.Second
+	}
+	probe := func(conn *sqlx.Conn) error {
+		probeCtx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		var value interface{}
+		return conn.QueryRowxContext(probeCtx, probeQuery).Scan(&value)
+	}
+
+	// Open the connections and make sure each one works before it goes idle.
+	// Their timings carry the network error that killed them, which the driver doesn't report.
+	var conns []*sqlx.Conn
+	var timings []*dialTiming
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for i := 0; i < tc.Connections; i++ {
+		timing := &dialTiming{}
+		conn, err := db.Connx(withDialTiming(ctx, timing))
+		if err == nil {
+			if err = probe(conn); err != nil {
+				conn.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("Idle test of target %s for %v failed to open connection %d: %v", target, interval, i+1, err)
+			continue
+		}
+		conns = append(conns, conn)
+		timings = append(timings, timing)
+	}
+	log.Printf("Idle test of target %s holding %d connections idle for %v", target, len(conns), interval)
+
+	select {
+	case <-ctx.Done():
+		return idleResult{}, false
+	case <-time.After(interval):
+	}
+
+	result := idleResult{interval: interval, errors: map[string]int{}}
+	for i, conn := range conns {
+		err := probe(conn)
+		if err == nil {
+			result.alive++
+			idleProbes.WithLabelValues(target, interval.String(), "alive", "").Inc()
+			continue
+		}
+		if ioErr := timings[i].ioError(); ioErr != nil {
+			err = ioErr
+		}
+		code := classifyError(err).Code
+		result.dead++
+		result.errors[code]++
+		idleProbes.WithLabelValues(target, interval.String(), "dead", code).Inc()
+		if debug {
+			log.Printf("Idle test of target %s connection dead after %v: %v", target, interval, err)
+		}
+	}
+	log.Printf("Idle test of target %s after %v: %d alive, %d dead", target, interval, result.alive, result.dead)
+	return result, true
+}
+
+// summarizeIdle reports every interval and, when some connections died, suggests a conn_idle_timeout:
+// the longest interval shorter than the first failing one where every connection survived,
+// or half of the first failing interval when even the shortest one lost connections
+func summarizeIdle(results []idleResult) *IdleSummary {
+	summary := &IdleSummary{Intervals: make([]IdleIntervalSummary, 0, len(results))}
+	var survived time.Duration
+	for _, r := range results {
+		summary.Intervals = append(summary.Intervals, IdleIntervalSummary{
+			Interval: r.interval.String(),
+			Alive:    r.alive,
+			Dead:     r.dead,
+			Errors:   r.errors,
+		})
+		if summary.CutoffFound {
+			continue
+		}
+		if r.dead > 0 {
+			summary.CutoffFound = true
+			suggested := survived
+			if suggested == 0 {
+				suggested = r.interval / 2
+			}
+			summary.SuggestedTimeout = suggested.String()
+		} else if r.alive > 0 {
+			survived = r.interval
+		}
+	}
+	return summary
+}
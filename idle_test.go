@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProbeIdleInterval(t *testing.T) {
+	resetMetrics()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	// Both connections work when opened, one is gone after the idle interval
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	}
+	mock.ExpectQuery("SELECT 1").WillReturnError(mysql.ErrInvalidConn)
+
+	tc := &IdleTestConfig{Connections: 2}
+	result, ok := probeIdleInterval(context.Background(), sqlxDB, "primary", 10*time.Millisecond, tc)
+	if !ok {
+		t.Fatalf("Expected the interval to complete")
+	}
+	if result.alive != 1 || result.dead != 1 || result.errors["invalid_conn"] != 1 {
+		t.Errorf("Expected one alive and one invalid_conn connection, got %+v", result)
+	}
+	if value := testutil.ToFloat64(idleProbes.WithLabelValues("primary", "10ms", "dead", "invalid_conn")); value != 1 {
+		t.Errorf("Expected one dead probe, got %v", value)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	// A cancelled run leaves the interval out
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok := probeIdleInterval(ctx, sqlxDB, "primary", time.Hour, &IdleTestConfig{}); ok {
+		t.Errorf("Expected a cancelled interval not to complete")
+	}
+}
+
+func TestSummarizeIdle(t *testing.T) {
+	results := []idleResult{
+		{interval: time.Minute, alive: 5},
+		{interval: 5 * time.Minute, alive: 5},
+		{interval: 15 * time.Minute, alive: 2, dead: 3, errors: map[string]int{"2013": 3}},
+		{interval: time.Hour, dead: 5, errors: map[string]int{"eof": 5}},
+	}
+	summary := summarizeIdle(results)
+	if !summary.CutoffFound || summary.SuggestedTimeout != "5m0s" {
+		t.Errorf("Expected a 5m0s suggestion, got %+v", summary)
+	}
+	if len(summary.Intervals) != 4 || summary.Intervals[2].Errors["2013"] != 3 {
+		t.Errorf("Unexpected intervals: %+v", summary.Intervals)
+	}
+
+	// Half of the first interval when even that one lost connections
+	summary = summarizeIdle(results[2:])
+	if summary.SuggestedTimeout != "7m30s" {
+		t.Errorf("Expected a 7m30s suggestion, got %s", summary.SuggestedTimeout)
+	}
+
+	// No suggestion when every connection survived
+	summary = summarizeIdle(results[:2])
+	if summary.CutoffFound || summary.SuggestedTimeout != "" {
+		t.Errorf("Expected no cutoff, got %+v", summary)
+	}
+}
+
+// serveIdleMySQL accepts MySQL connections on the listener, answers the first SELECT 1
+// of every connection and then closes it, like a server dropping an idle connection
+func serveIdleMySQL(listener net.Listener) {
+	writePacket := func(conn net.Conn, seq byte, payload []byte) {
+		header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), seq}
+		conn.Write(append(header, payload...))
+	}
+	readPacket := func(conn net.Conn) ([]byte, error) {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return nil, err
+		}
+		payload := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+		_, err := io.ReadFull(conn, payload)
+		return payload, err
+	}
+	lenencString := func(s string) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+
+			// Protocol 41 handshake with mysql_native_password, then OK for any credentials
+			handshake := []byte{10}
+			handshake = append(handshake, "8.0.0\x00"...)
+			handshake = append(handshake, 1, 0, 0, 0)
+			handshake = append(handshake, "abcdefgh"...)
+			handshake = append(handshake, 0, 0x01, 0xa2, 0x21, 0x02, 0x00, 0x08, 0x00, 21)
+			handshake = append(handshake, make([]byte, 10)...)
+			handshake = append(handshake, "ijklmnopqrst\x00"...)
+			handshake = append(handshake, "mysql_native_password\x00"...)
+			writePacket(conn, 0, handshake)
+			if _, err := readPacket(conn); err != nil {
+				return
+			}
+			writePacket(conn, 2, []byte{0, 0, 0, 0x02, 0, 0, 0})
+
+			// One row with a single BIGINT column, then the connection is dropped
+			if _, err := readPacket(conn); err != nil {
+				return
+			}
+			writePacket(conn, 1, []byte{1})
+			column := append(lenencString("def"), 0, 0, 0)
+			column = append(column, lenencString("1")...)
+			column = append(column, 0, 0x0c, 0x3f, 0, 1, 0, 0, 0, 0x08, 0x81, 0, 0, 0, 0)
+			writePacket(conn, 2, column)
+			writePacket(conn, 3, []byte{0xfe, 0, 0, 0x02, 0})
+			writePacket(conn, 4, lenencString("1"))
+			writePacket(conn, 5, []byte{0xfe, 0, 0, 0x02, 0})
+		}(conn)
+	}
+}
+
+func TestProbeIdleIntervalNetworkError(t *testing.T) {
+	resetMetrics()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go serveIdleMySQL(listener)
+
+	connector, err := newTimedConnector("primary", fmt.Sprintf("root@tcp(%s)/", listener.Addr()))
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	defer db.Close()
+
+	// The driver only reports an invalid connection, the probe reports how the server closed it
+	result, ok := probeIdleInterval(context.Background(), db, "primary", 50*time.Millisecond, &IdleTestConfig{Connections: 1})
+	if !ok {
+		t.Fatalf("Expected the interval to complete")
+	}
+	if result.dead != 1 || result.errors["eof"] != 1 {
+		t.Errorf("Expected one connection closed by the server, got %+v", result)
+	}
+	if value := testutil.ToFloat64(idleProbes.WithLabelValues("primary", "50ms", "dead", "eof")); value != 1 {
+		t.Errorf("Expected one dead probe reported as eof, got %v", value)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+// profileTick is how often a worker profile re-checks the target concurrency
+const profileTick = 250 * time.Millisecond
+
+// LoadStage is one step of a load profile.
+// A stage targets either a query rate (open loop) or a number of workers (closed loop).
+type LoadStage struct {
+	Type     string        `yaml:"type"` // ramp, step, spike or sine
+	Duration time.Duration `yaml:"duration"`
+	Rate     float64       `yaml:"rate"`
+	Workers  int           `yaml:"workers"`
+	Period   time.Duration `yaml:"period"` // sine only, defaults to the stage duration
+}
+
+// LoadProfileConfig describes how the load of a scenario changes over time
+type LoadProfileConfig struct {
+	Stages []LoadStage `yaml:"stages"`
+}
+
+// validate checks the stage types and that the profile doesn't mix rates and workers
+func (p *LoadProfileConfig) validate() error {
+	if len(p.Stages) == 0 {
+		return fmt.Errorf("load profile has no stages")
+	}
+	rate := p.isRate()
+	for i, s := range p.Stages {
+		switch s.Type {
+		case "", "ramp", "step", "spike", "sine":
+		default:
+			return fmt.Errorf("stage %d has unknown type %q", i+1, s.Type)
+		}
+		if s.Duration <= 0 {
+			return fmt.Errorf("stage %d needs a positive duration", i+1)
+		}
+		if s.Rate < 0 || s.Workers < 0 {
+			return fmt.Errorf("stage %d has a negative target", i+1)
+		}
+		if (s.Rate > 0 && !rate) || (s.Workers > 0 && rate) {
+			return fmt.Errorf("stage %d mixes rate and workers targets", i+1)
+		}
+	}
+	return nil
+}
+
+// isRate reports whether the profile targets a query rate rather than a number of workers
+func (p *LoadProfileConfig) isRate() bool {
+	for _, s := range p.Stages {
+		if s.Rate > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// maxLevel returns the highest target of any stage
+func (p *LoadProfileConfig) maxLevel() float64 {
+	highest := 0.0
+	for _, s := range p.Stages {
+		highest = math.Max(highest, s.target())
+	}
+	return highest
+}
+
+// levelAt returns the current stage index and target level after elapsed time,
+// and whether the profile has finished. The profile starts from a level of zero.
+func (p *LoadProfileConfig) levelAt(elapsed time.Duration) (int, float64, bool) {
+	from := 0.0
+	for i := range p.Stages {
+		s := &p.Stages[i]
+		if elapsed < s.Duration {
+			return i, s.level(from, elapsed), false
+		}
+		elapsed -= s.Duration
+		from = s.endLevel(from)
+	}
+	return len(p.Stages), from, true
+}
+
+// target returns the rate or number of workers the stage aims for
+func (s *LoadStage) target() float64 {
+	if s.Rate > 0 {
+		return s.Rate
+	}
+	return float64(s.Workers)
+}
+
+// level returns the target level at time t into the stage, starting from the previous level
+func (s *LoadStage) level(from float64, t time.Duration) float64 {
+	target := s.target()
+	switch s.Type {
+	case "ramp":
+		// Linear ramp from the previous level to the target
+		return from + (target-from)*float64(t)/float64(s.Duration)
+	case "sine":
+		// Oscillate between the previous level and the target
+		period := s.Period
+		if period <= 0 {
+			period = s.Duration
+		}
+		phase := 2 * math.Pi * float64(t) / float64(period)
+		return from + (target-from)*(1-math.Cos(phase))/2
+	default:
+		// Steps and spikes jump straight to the target
+		return target
+	}
+}
+
+// endLevel returns the level the next stage starts from.
+// Spikes and waves return to the level they started from.
+func (s *LoadStage) endLevel(from float64) float64 {
+	switch s.Type {
+	case "spike", "sine":
+		return from
+	default:
+		return s.target()
+	}
+}
+
+// setLoadStage exports the current stage and target level of a scenario on a target
+func setLoadStage(target, scenario string, stage int, level float64) {
+	loadProfileStage.WithLabelValues(target, scenario).Set(float64(stage + 1))
+	loadProfileTarget.WithLabelValues(target, scenario).Set(level)
+}
+
+// RunWorkerProfile adds and removes closed-loop workers to follow the scenario's load profile.
+// Workers are numbered from firstWorkerID. All workers are stopped when the profile finishes.
+func RunWorkerProfile(ctx context.Context, cfg *Config, sc *ScenarioConfig, target *Target, firstWorkerID int) {
+	scenario := sc.label()
+	ticker := time.NewTicker(profileTick)
+	defer ticker.Stop()
+
+	// Stop every worker and wait for its last query before returning
+	var workers sync.WaitGroup
+	var cancels []context.CancelFunc
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		workers.Wait()
+	}()
+
+	start := time.Now()
+	for {
+		stage, level, done := sc.LoadProfile.levelAt(time.Since(start))
+		if done {
+			log.Printf("Load profile for scenario %s finished", scenario)
+			setLoadStage(target.Name, scenario, stage, 0)
+			return
+		}
+		setLoadStage(target.Name, scenario, stage, level)
+
+		// Start or stop workers until the target concurrency is reached
+		want := int(math.Round(level))
+		for len(cancels) < want {
+			workerCtx, cancel := context.WithCancel(ctx)
+			workers.Add(1)
+			go func(workerID int) {
+				defer workers.Done()
+				RunQueryWorkers(workerCtx, cfg, sc, target, workerID)
+			}(firstWorkerID + len(cancels))
+			cancels = append(cancels, cancel)
+		}
+		for len(cancels) > want {
+			cancels[len(cancels)-1]()
+			cancels = cancels[:len(cancels)-1]
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
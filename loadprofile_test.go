@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestLoadProfileLevelAt(t *testing.T) {
+	profile := &LoadProfileConfig{
+		Stages: []LoadStage{
+			{Type: "ramp", Duration: 10 * time.Second, Rate: 100},
+			{Type: "step", Duration: 10 * time.Second, Rate: 200},
+			{Type: "spike", Duration: 2 * time.Second, Rate: 1000},
+			{Type: "sine", Duration: 20 * time.Second, Rate: 400, Period: 10 * time.Second},
+		},
+	}
+	if err := profile.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		stage   int
+		level   float64
+	}{
+		{0, 0, 0},
+		{5 * time.Second, 0, 50},
+		{15 * time.Second, 1, 200},
+		{21 * time.Second, 2, 1000},
+		{22 * time.Second, 3, 200}, // The wave starts from the level before the spike
+		{27 * time.Second, 3, 400}, // Half a period in, the wave peaks
+		{32 * time.Second, 3, 200}, // A full period in, it is back at the base
+	}
+	for _, tt := range tests {
+		stage, level, done := profile.levelAt(tt.elapsed)
+		if done {
+			t.Fatalf("Profile finished early at %v", tt.elapsed)
+		}
+		if stage != tt.stage || math.Abs(level-tt.level) > 0.001 {
+			t.Errorf("At %v expected stage %d level %v, got stage %d level %v", tt.elapsed, tt.stage, tt.level, stage, level)
+		}
+	}
+
+	if _, _, done := profile.levelAt(42 * time.Second); !done {
+		t.Errorf("Expected profile to be finished after 42s")
+	}
+	if profile.maxLevel() != 1000 {
+		t.Errorf("Expected max level 1000, got %v", profile.maxLevel())
+	}
+}
+
+func TestLoadProfileValidate(t *testing.T) {
+	bad := []*LoadProfileConfig{
+		{},
+		{Stages: []LoadStage{{Type: "square", Duration: time.Second, Rate: 10}}},
+		{Stages: []LoadStage{{Type: "step", Rate: 10}}},
+		{Stages: []LoadStage{{Type: "step", Duration: time.Second, Rate: 10}, {Type: "step", Duration: time.Second, Workers: 2}}},
+	}
+	for i, profile := range bad {
+		if err := profile.validate(); err == nil {
+			t.Errorf("Expected validation error for profile %d", i)
+		}
+	}
+
+	// Profiles decode from yaml with duration strings
+	content := `
+stages:
+  - type: ramp
+    duration: 30s
+    workers: 20
+  - type: step
+    duration: 1m
+    workers: 20
+`
+	var profile LoadProfileConfig
+	if err := yaml.Unmarshal([]byte(content), &profile); err != nil {
+		t.Fatalf("Failed to unmarshal load profile: %v", err)
+	}
+	if err := profile.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if profile.isRate() || profile.Stages[1].Duration != time.Minute {
+		t.Errorf("Unexpected load profile: %+v", profile)
+	}
+}
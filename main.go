@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"log"
+	"os"
 )
 
 func main() {
@@ -14,6 +16,11 @@ func main() {
 
 	// Start the command using the loaded configuration and the real database initializer
 	if err := StartCmdWithConfig(cfg, InitializeDBWrapper); err != nil {
+		// Use a distinct exit code so pipelines can tell a failed gate from a broken run
+		if errors.Is(err, errAssertionsFailed) {
+			log.Printf("Run failed: %v", err)
+			os.Exit(2)
+		}
 		log.Fatalf("Application failed to start: %v", err)
 	}
 
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultMaxInFlight is the open-loop safety limit unless max_in_flight is set
+const defaultMaxInFlight = 10000
+
+// RunOpenLoop dispatches queries at the scenario's target rate no matter how many are still in flight.
+// Latency is measured from the intended dispatch time, so a slow server shows up as tail latency
+// instead of quietly lowering the load. Only max_in_flight bounds the queries in flight as a safety limit;
+// dispatches over it wait for a free slot, still timed from their intended dispatch time.
+func RunOpenLoop(ctx context.Context, cfg *Config, sc *ScenarioConfig, target *Target, workerID int) {
+	run, err := prepareWorkload(ctx, cfg, sc, target, workerID)
+	if err != nil {
+		return
+	}
+	defer run.Close()
+
+	// Let queries still in flight finish before the workload is torn down
+	var pending sync.WaitGroup
+	defer pending.Wait()
+
+	scenario := sc.label()
+	maxInFlight := sc.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = defaultMaxInFlight
+	}
+	inFlight := make(chan struct{}, maxInFlight)
+	rng := newWorkerRand(run.randomSeed, workerID, 0)
+
+	log.Printf("Starting open loop for scenario %s on target %s at %.2f queries/s with at most %d in flight", scenario, target.Name, sc.TargetRate, maxInFlight)
+	if sc.LoadProfile != nil {
+		log.Printf("Scenario %s follows a load profile with %d stages", scenario, len(sc.LoadProfile.Stages))
+	}
+
+	// The rate is constant unless a load profile is configured
+	rateAt := func(elapsed time.Duration) (float64, bool) {
+		return sc.TargetRate, false
+	}
+	if sc.LoadProfile != nil {
+		rateAt = func(elapsed time.Duration) (float64, bool) {
+			stage, level, done := sc.LoadProfile.levelAt(elapsed)
+			if done {
+				level = 0
+			}
+			setLoadStage(target.Name, scenario, stage, level)
+			return level, done
+		}
+	}
+
+	start := time.Now()
+	intended := start
+	owed := 0.0 // Share of a query accrued at slow profile rates
+	for n := 0; ; {
+		if wait := time.Until(intended); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		rate, done := rateAt(intended.Sub(start))
+		if done {
+			log.Printf("Load profile for scenario %s finished", scenario)
+			return
+		}
+		if rate <= 0 {
+			// Nothing to dispatch at a zero rate, check again shortly
+			owed = 0
+			intended = intended.Add(profileTick)
+			continue
+		}
+
+		// Schedule from the previous intended time so a delayed dispatch doesn't shift the ones after it
+		interval := time.Duration(float64(time.Second) / rate)
+		dispatchTime := intended
+		if sc.LoadProfile != nil && interval > profileTick {
+			// The profile may raise the rate long before a slow dispatch is due,
+			// so re-check it every tick and dispatch once a whole query has accrued
+			interval = profileTick
+			intended = intended.Add(profileTick)
+			owed += rate * profileTick.Seconds()
+			if owed < 1 {
+				continue
+			}
+			owed--
+		} else {
+			owed = 0
+			intended = intended.Add(interval)
+		}
+		n++
+		if time.Since(dispatchTime) > interval {
+			missedDispatches.WithLabelValues(target.Name, scenario, "late").Inc()
+		}
+
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			missedDispatches.WithLabelValues(target.Name, scenario, "queued").Inc()
+			select {
+			case inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		work := run.next(rng)
+		queriesInFlight.WithLabelValues(target.Name, scenario).Inc()
+		pending.Add(1)
+		go func(n int) {
+			defer func() {
+				queriesInFlight.WithLabelValues(target.Name, scenario).Dec()
+				<-inFlight
+				pending.Done()
+			}()
+			work(workerID, n, dispatchTime)
+		}(n)
+	}
+}
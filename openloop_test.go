@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRunOpenLoopQueuesOverMaxInFlight(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	// Each query takes far longer than the dispatch interval
+	for i := 0; i < 3; i++ {
+		mock.ExpectQuery("SELECT 1").
+			WillDelayFor(100 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	}
+
+	cfg := &Config{}
+	sc := &ScenarioConfig{
+		Name:        "open",
+		TestQuery:   "SELECT 1",
+		TargetRate:  100,
+		MaxInFlight: 1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	RunOpenLoop(ctx, cfg, sc, &Target{Name: "primary", Config: &cfg.Database, DB: sqlxDB}, 0)
+
+	// With one query allowed in flight the dispatches wait for it instead of being dropped,
+	// and their latency includes the wait from their intended dispatch time
+	if queued := testutil.ToFloat64(missedDispatches.WithLabelValues("primary", "open", "queued")); queued < 2 {
+		t.Errorf("Expected at least 2 queued dispatches, got %v", queued)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+	var slowest time.Duration
+	for _, h := range runStats.byQuery {
+		if h.max > slowest {
+			slowest = h.max
+		}
+	}
+	if slowest < 150*time.Millisecond {
+		t.Errorf("Expected the queued query to be timed from its intended dispatch, slowest took %v", slowest)
+	}
+}
+
+func TestRunOpenLoopFollowsProfileFromSlowRate(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+	mock.MatchExpectationsInOrder(false)
+	for i := 0; i < 50; i++ {
+		mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"1"}).AddRow(1))
+	}
+
+	// A query every 10s at first, then 40 a second. The first dispatch must not wait out the slow interval.
+	cfg := &Config{}
+	sc := &ScenarioConfig{
+		Name:              "profile",
+		TestQuery:         "SELECT 1",
+		ConcurrentWorkers: 1,
+		QueriesPerWorker:  10,
+		LoadProfile: &LoadProfileConfig{Stages: []LoadStage{
+			{Type: "step", Duration: 500 * time.Millisecond, Rate: 0.1},
+			{Type: "step", Duration: 500 * time.Millisecond, Rate: 40},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	RunOpenLoop(ctx, cfg, sc, &Target{Name: "primary", Config: &cfg.Database, DB: sqlxDB}, 0)
+
+	if runStats.total < 10 {
+		t.Errorf("Expected the second stage to dispatch at least 10 queries, got %d", runStats.total)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statement modes
+const (
+	statementModePerQuery     = "per_query"    // The driver's default, see statementModeLabel
+	statementModePrepared     = "prepared"     // Prepare every statement once per connection and reuse it
+	statementModeInterpolated = "interpolated" // Per query with interpolateParams=true, sent as text
+)
+
+// statementStatusInterval is how often the prepared statement counters of every target are read
+const statementStatusInterval = 10 * time.Second
+
+// statementModeLabel names how the statements of a scenario reach a target's server.
+// Per query, the driver prepares, executes and closes every statement with parameters,
+// unless the DSN sets interpolateParams and it sends them as text.
+func statementModeLabel(mode, dsn string) string {
+	if mode != statementModePerQuery {
+		return mode
+	}
+	if cfg, err := mysql.ParseDSN(dsn); err == nil && cfg.InterpolateParams {
+		return statementModeInterpolated
+	}
+	return mode
+}
+
+// preparedStatement runs a prepared statement through runStatement, which passes the SQL it was prepared from
+type preparedStatement struct {
+	*sqlx.Stmt
+}
+
+func (s preparedStatement) ExecContext(ctx context.Context, _ string, args ...interface{}) (sql.Result, error) {
+	return s.Stmt.ExecContext(ctx, args...)
+}
+
+func (s preparedStatement) QueryxContext(ctx context.Context, _ string, args ...interface{}) (*sqlx.Rows, error) {
+	return s.Stmt.QueryxContext(ctx, args...)
+}
+
+// statementCache shares prepared statements between the workers of every scenario.
+// database/sql prepares each statement again on every connection it runs on and reuses it from then on.
+type statementCache struct {
+	mu    sync.Mutex
+	stmts map[[2]string]*cachedStatement // Keyed by target and SQL
+}
+
+// cachedStatement is prepared once, however many workers ask for it at the same time
+type cachedStatement struct {
+	once sync.Once
+	stmt *sqlx.Stmt
+	err  error
+}
+
+// preparedStmts is the shared cache of scenarios in prepared statement mode
+var preparedStmts = newStatementCache()
+
+func newStatementCache() *statementCache {
+	return &statementCache{stmts: make(map[[2]string]*cachedStatement)}
+}
+
+// prepare returns the statement prepared on a target, preparing it on the first call.
+// Statements are prepared outside the lock so a slow prepare only holds up the workers waiting for it.
+// A failed prepare is forgotten once its waiters have the error, the next call tries again.
+func (c *statementCache) prepare(ctx context.Context, db *sqlx.DB, target, query string) (*sqlx.Stmt, error) {
+	key := [2]string{target, query}
+	c.mu.Lock()
+	entry, ok := c.stmts[key]
+	if !ok {
+		entry = &cachedStatement{}
+		c.stmts[key] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.stmt, entry.err = db.PreparexContext(ctx, query)
+	})
+	if entry.err != nil {
+		c.mu.Lock()
+		if c.stmts[key] == entry {
+			delete(c.stmts, key)
+		}
+		c.mu.Unlock()
+		return nil, entry.err
+	}
+	return entry.stmt, nil
+}
+
+// query returns a runner that executes every statement as a prepared statement.
+// Only the first prepare reports prepare errors, later ones on new connections fail the execution.
+func (c *statementCache) query(db *sqlx.DB, target string) queryRunner {
+	return func(query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+		ctx := context.Background()
+		stmt, err := c.prepare(ctx, db, target, query)
+		if err != nil {
+			return nil, nil, &phaseError{phase: phasePrepare, err: err}
+		}
+		return runStatement(ctx, preparedStatement{stmt}, query, values)
+	}
+}
+
+// Close closes every prepared statement, after waiting for the prepares still running
+func (c *statementCache) Close() {
+	c.mu.Lock()
+	stmts := c.stmts
+	c.stmts = make(map[[2]string]*cachedStatement)
+	c.mu.Unlock()
+
+	for _, entry := range stmts {
+		entry.once.Do(func() {})
+		if entry.stmt != nil {
+			entry.stmt.Close()
+		}
+	}
+}
+
+// StatementStatusSummary is the prepared statement activity of one target's server during the run.
+// The Com_stmt counters are server wide, so they include other clients.
+type StatementStatusSummary struct {
+	Target               string `json:"target"`
+	ComStmtPrepare       int64  `json:"com_stmt_prepare"`
+	ComStmtExecute       int64  `json:"com_stmt_execute"`
+	ComStmtClose         int64  `json:"com_stmt_close"`
+	PreparedStmtCount    int64  `json:"prepared_stmt_count"` // Open at the end of the run
+	MaxPreparedStmtCount int64  `json:"max_prepared_stmt_count"`
+}
+
+// StatementSummary compares the statement modes of the run
+type StatementSummary struct {
+	Modes   []LatencySummary         `json:"modes"`
+	Servers []StatementStatusSummary `json:"servers,omitempty"`
+}
+
+// statementStatusVariables are read from SHOW GLOBAL STATUS and SHOW GLOBAL VARIABLES
+var statementStatusVariables = []string{"Com_stmt_prepare", "Com_stmt_execute", "Com_stmt_close", "Prepared_stmt_count", "max_prepared_stmt_count"}
+
+// readStatementStatus reads the prepared statement counters of a target
+func readStatementStatus(ctx context.Context, target *Target) (map[string]int64, error) {
+	status := make(map[string]int64)
+	for _, query := range []string{
+		"SHOW GLOBAL STATUS WHERE Variable_name IN ('Com_stmt_prepare', 'Com_stmt_execute', 'Com_stmt_close', 'Prepared_stmt_count')",
+		"SHOW GLOBAL VARIABLES LIKE 'max_prepared_stmt_count'",
+	} {
+		rows, err := target.DB.QueryxContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name, value string
+			if err := rows.Scan(&name, &value); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				status[name] = n
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range statementStatusVariables {
+		statementStatus.WithLabelValues(target.Name, name).Set(float64(status[name]))
+	}
+	return status, nil
+}
+
+// RunStatementStatus reads the prepared statement counters of every target until ctx is cancelled,
+// and reports the change over the run. Targets that never answered are left out.
+func RunStatementStatus(ctx context.Context, targets []*Target) []StatementStatusSummary {
+	first := make([]map[string]int64, len(targets))
+	last := make([]map[string]int64, len(targets))
+	sample := func() {
+		for i, target := range targets {
+			// Use a fresh context so the last sample still runs after ctx is cancelled
+			sampleCtx, cancel := context.WithTimeout(context.Background(), statementStatusInterval)
+			status, err := readStatementStatus(sampleCtx, target)
+			cancel()
+			if err != nil {
+				log.Printf("Failed to read prepared statement status of %s: %v", target.Name, err)
+				continue
+			}
+			if first[i] == nil {
+				first[i] = status
+			}
+			last[i] = status
+		}
+	}
+
+	ticker := time.NewTicker(statementStatusInterval)
+	defer ticker.Stop()
+	sample()
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+		}
+		sample()
+	}
+
+	var result []StatementStatusSummary
+	for i, target := range targets {
+		if first[i] == nil {
+			continue
+		}
+		result = append(result, StatementStatusSummary{
+			Target:               target.Name,
+			ComStmtPrepare:       last[i]["Com_stmt_prepare"] - first[i]["Com_stmt_prepare"],
+			ComStmtExecute:       last[i]["Com_stmt_execute"] - first[i]["Com_stmt_execute"],
+			ComStmtClose:         last[i]["Com_stmt_close"] - first[i]["Com_stmt_close"],
+			PreparedStmtCount:    last[i]["Prepared_stmt_count"],
+			MaxPreparedStmtCount: last[i]["max_prepared_stmt_count"],
+		})
+	}
+	return result
+}
+
+// checkStatementMode rejects unknown statement modes
+func checkStatementMode(mode string) error {
+	switch mode {
+	case "", statementModePerQuery, statementModePrepared:
+		return nil
+	}
+	return fmt.Errorf("unknown statement mode %q", mode)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatementModeLabel(t *testing.T) {
+	tests := []struct {
+		mode, dsn, expected string
+	}{
+		{statementModePerQuery, "root@tcp(127.0.0.1:3306)/test", statementModePerQuery},
+		{statementModePerQuery, "root@tcp(127.0.0.1:3306)/test?interpolateParams=true", statementModeInterpolated},
+		{statementModePrepared, "root@tcp(127.0.0.1:3306)/test?interpolateParams=true", statementModePrepared},
+	}
+	for _, test := range tests {
+		if label := statementModeLabel(test.mode, test.dsn); label != test.expected {
+			t.Errorf("Expected %s for mode %s and DSN %s, got %s", test.expected, test.mode, test.dsn, label)
+		}
+	}
+
+	if err := checkStatementMode("binary"); err == nil {
+		t.Errorf("Expected error for unknown statement mode")
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	cache := newStatementCache()
+	runQuery := cache.query(sqlx.NewDb(db, "mysql"), "primary")
+
+	// Prepared once, executed twice
+	prepared := mock.ExpectPrepare("SELECT name FROM users WHERE id = \\?")
+	prepared.ExpectQuery().WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("alice"))
+	prepared.ExpectQuery().WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("bob"))
+	for i, expected := range []string{"alice", "bob"} {
+		_, rows, err := runQuery("SELECT name FROM users WHERE id = ?", []interface{}{i + 1})
+		if err != nil || len(rows) != 1 || rows[0]["name"] != expected {
+			t.Errorf("Expected %s, got %v: %v", expected, rows, err)
+		}
+	}
+
+	// Writes are executed on their prepared statement
+	mock.ExpectPrepare("DELETE FROM users").ExpectExec().WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	if _, rows, err := runQuery("DELETE FROM users WHERE id = ?", []interface{}{3}); err != nil || rows[0]["rows_affected"] != int64(1) {
+		t.Errorf("Expected 1 row affected, got %v: %v", rows, err)
+	}
+
+	// A statement the server refuses to prepare fails in the prepare phase
+	mock.ExpectPrepare("SELECT broken").WillReturnError(&mysql.MySQLError{Number: 1461, Message: "Can't create more than max_prepared_stmt_count statements"})
+	_, _, err = runQuery("SELECT broken", nil)
+	if class := classifyError(err); class.Phase != phasePrepare || class.Code != "1461" {
+		t.Errorf("Expected a prepare/1461 error, got %v", class)
+	}
+
+	// The failure isn't cached, the next execution prepares it again
+	mock.ExpectPrepare("SELECT broken").ExpectQuery().WillReturnRows(sqlmock.NewRows([]string{"broken"}).AddRow(1))
+	if _, _, err := runQuery("SELECT broken", nil); err != nil {
+		t.Errorf("Expected the second prepare to succeed: %v", err)
+	}
+
+	cache.Close()
+	if len(cache.stmts) != 0 {
+		t.Errorf("Expected no statements after Close, got %d", len(cache.stmts))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+}
+
+func TestStatementCacheConcurrentPrepare(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+	mock.MatchExpectationsInOrder(false)
+
+	// Workers asking for a slow statement together prepare it once
+	mock.ExpectPrepare("SELECT slow").WillDelayFor(300 * time.Millisecond)
+	mock.ExpectPrepare("SELECT fast")
+	cache := newStatementCache()
+	defer cache.Close()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	stmts := make([]*sqlx.Stmt, 5)
+	for i := range stmts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stmts[i], _ = cache.prepare(ctx, sqlxDB, "primary", "SELECT slow")
+		}(i)
+	}
+
+	// Another statement doesn't wait for the slow one
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := cache.prepare(ctx, sqlxDB, "primary", "SELECT fast"); err != nil {
+		t.Fatalf("Failed to prepare: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected the fast prepare not to wait for the slow one, took %v", elapsed)
+	}
+
+	wg.Wait()
+	for i, stmt := range stmts {
+		if stmt == nil || stmt != stmts[0] {
+			t.Errorf("Expected every worker to get the same statement, worker %d got %p", i, stmt)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+}
+
+func TestReadStatementStatus(t *testing.T) {
+	resetMetrics()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	target := &Target{Name: "primary", DB: sqlx.NewDb(db, "mysql")}
+
+	mock.ExpectQuery("SHOW GLOBAL STATUS").WillReturnRows(sqlmock.NewRows([]string{"Variable_name", "Value"}).
+		AddRow("Com_stmt_prepare", "12").AddRow("Com_stmt_execute", "340").AddRow("Com_stmt_close", "10").AddRow("Prepared_stmt_count", "2"))
+	mock.ExpectQuery("SHOW GLOBAL VARIABLES").WillReturnRows(sqlmock.NewRows([]string{"Variable_name", "Value"}).
+		AddRow("max_prepared_stmt_count", "16382"))
+	status, err := readStatementStatus(context.Background(), target)
+	if err != nil {
+		t.Fatalf("Failed to read statement status: %v", err)
+	}
+	if status["Com_stmt_prepare"] != 12 || status["max_prepared_stmt_count"] != 16382 {
+		t.Errorf("Unexpected statement status: %v", status)
+	}
+	if v := testutil.ToFloat64(statementStatus.WithLabelValues("primary", "Com_stmt_execute")); v != 340 {
+		t.Errorf("Expected Com_stmt_execute 340, got %v", v)
+	}
+
+	// Servers and proxies that refuse SHOW GLOBAL STATUS return the error
+	mock.ExpectQuery("SHOW GLOBAL STATUS").WillReturnError(errors.New("not supported"))
+	if _, err := readStatementStatus(context.Background(), target); err == nil {
+		t.Errorf("Expected error from SHOW GLOBAL STATUS")
+	}
+}
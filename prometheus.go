@@ -3,7 +3,9 @@ package main
 import (
 	"log"
 	"net/http"
+	"sync"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
@@ -13,9 +15,9 @@ var (
 	queryErrors = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "db_query_errors_total",
-			Help: "Total number of SQL query errors",
+			Help: "Total number of SQL query errors by phase, MySQL error number or driver error, and SQLSTATE",
 		},
-		[]string{"worker_id", "query"},
+		[]string{"target", "worker_id", "query", "phase", "code", "sqlstate"},
 	)
 
 	queryDuration = prometheus.NewHistogramVec(
@@ -24,41 +26,326 @@ var (
 			Help:    "Histogram of SQL query execution times",
 			Buckets: prometheus.DefBuckets, // Default buckets: [0.005, 0.01, 0.025, ...]
 		},
-		[]string{"worker_id", "query"},
+		[]string{"target", "worker_id", "query"},
 	)
 
-	openConnections = prometheus.NewGaugeVec(
+	connectPhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_connect_phase_duration_seconds",
+			Help:    "Histogram of DNS, TCP connect, TLS handshake and MySQL auth times for new connections",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target", "host", "phase"},
+	)
+
+	churnDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_churn_duration_seconds",
+			Help:    "Histogram of TCP connect, handshake/auth and query times in connection churn mode",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target", "scenario", "phase"},
+	)
+
+	missedDispatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_open_loop_missed_dispatches_total",
+			Help: "Total number of open-loop dispatches that started late or queued at max_in_flight",
+		},
+		[]string{"target", "scenario", "reason"},
+	)
+
+	queriesInFlight = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "db_open_connections",
-			Help: "Number of open connections in the DB connection pool",
+			Name: "db_open_loop_queries_in_flight",
+			Help: "Number of open-loop queries currently in flight",
+		},
+		[]string{"target", "scenario"},
+	)
+
+	loadProfileStage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_load_profile_stage",
+			Help: "Current stage of the load profile, starting at 1",
+		},
+		[]string{"target", "scenario"},
+	)
+
+	loadProfileTarget = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_load_profile_target",
+			Help: "Current target rate or number of workers of the load profile",
+		},
+		[]string{"target", "scenario"},
+	)
+
+	idleProbes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_idle_probes_total",
+			Help: "Total number of idle test probes by idle interval, result and error code of dead connections",
+		},
+		[]string{"target", "interval", "result", "code"},
+	)
+
+	backendQueries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_backend_queries_total",
+			Help: "Total number of queries served by each backend, from @@hostname and @@server_id",
+		},
+		[]string{"target", "scenario", "hostname", "server_id"},
+	)
+
+	backendSwitches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_backend_switches_total",
+			Help: "Total number of queries of a client connection served by another backend connection than its last one",
 		},
-		[]string{"pool"},
+		[]string{"target", "scenario"},
 	)
 
-	idleConnections = prometheus.NewGaugeVec(
+	identityErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_identity_errors_total",
+			Help: "Total number of failed backend identity lookups",
+		},
+		[]string{"target", "scenario"},
+	)
+
+	replicationLag = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "db_idle_connections",
-			Help: "Number of idle connections in the DB connection pool",
+			Name: "db_replication_lag_seconds",
+			Help: "Age of the heartbeat row last read on each replica",
 		},
-		[]string{"pool"},
+		[]string{"target"},
 	)
 
-	inUseConnections = prometheus.NewGaugeVec(
+	replicationLagDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_replication_lag_duration_seconds",
+			Help:    "Histogram of the heartbeat replication lag on each replica",
+			Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+		},
+		[]string{"target"},
+	)
+
+	secondsBehindSource = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "db_in_use_connections",
-			Help: "Number of in-use connections in the DB connection pool",
+			Name: "db_replica_seconds_behind_source",
+			Help: "Seconds_Behind_Source from SHOW REPLICA STATUS, -1 when replication isn't running",
+		},
+		[]string{"target"},
+	)
+
+	heartbeatErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_heartbeat_errors_total",
+			Help: "Total number of failed heartbeat writes, reads and replica status checks",
 		},
-		[]string{"pool"},
+		[]string{"target", "operation"},
+	)
+
+	splitStatements = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_split_statements_total",
+			Help: "Total number of tagged reads and writes by the role of the backend they landed on",
+		},
+		[]string{"target", "scenario", "kind", "backend"},
+	)
+
+	splitViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_split_violations_total",
+			Help: "Total number of reads that landed on the writer and writes that landed on a reader",
+		},
+		[]string{"target", "scenario", "kind", "hostname"},
+	)
+
+	rowsAffected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_rows_affected_total",
+			Help: "Total number of rows affected by executed INSERT, UPDATE, DELETE and other statements without a result set",
+		},
+		[]string{"target", "query"},
+	)
+
+	lastInsertID = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_last_insert_id",
+			Help: "Last insert ID reported by an executed statement",
+		},
+		[]string{"target", "query"},
+	)
+
+	transactionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_transaction_duration_seconds",
+			Help:    "Histogram of whole transaction times by outcome: commit, rollback or error",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target", "scenario", "outcome"},
+	)
+
+	transactionLockErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_transaction_lock_errors_total",
+			Help: "Total number of transactions that failed with a deadlock or lock wait timeout",
+		},
+		[]string{"target", "scenario", "error"},
+	)
+
+	statementModeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "db_statement_mode_duration_seconds",
+			Help:    "Histogram of SQL query execution times by statement mode: per_query, interpolated or prepared",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target", "mode"},
+	)
+
+	statementStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_server_statement_status",
+			Help: "Prepared statement counters of the server: Com_stmt_prepare, Com_stmt_execute, Com_stmt_close, Prepared_stmt_count and max_prepared_stmt_count",
+		},
+		[]string{"target", "variable"},
+	)
+
+	seedRows = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "db_seed_rows",
+			Help: "Number of seed rows loaded for a scenario",
+		},
+		[]string{"target", "scenario"},
+	)
+
+	consistencyComparisons = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "db_consistency_comparisons_total",
+			Help: "Total number of results compared with the reference target by outcome: match, diverged or error",
+		},
+		[]string{"target", "query", "result"},
 	)
 )
 
+// poolCollector reports the statistics of the connection pool of every target
+var poolCollector = newDBPoolCollector()
+
+// dbPoolCollector reads sql.DBStats of each registered pool at scrape time,
+// so every pool is reported exactly once no matter how many workers share it
+type dbPoolCollector struct {
+	mu    sync.Mutex
+	pools map[string]*sqlx.DB
+
+	openConnections    *prometheus.Desc
+	idleConnections    *prometheus.Desc
+	inUseConnections   *prometheus.Desc
+	maxOpenConnections *prometheus.Desc
+	waitCount          *prometheus.Desc
+	waitDuration       *prometheus.Desc
+	maxIdleClosed      *prometheus.Desc
+	maxIdleTimeClosed  *prometheus.Desc
+	maxLifetimeClosed  *prometheus.Desc
+}
+
+func newDBPoolCollector() *dbPoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(name, help, []string{"target"}, nil)
+	}
+	return &dbPoolCollector{
+		pools:              make(map[string]*sqlx.DB),
+		openConnections:    desc("db_open_connections", "Number of open connections in the DB connection pool"),
+		idleConnections:    desc("db_idle_connections", "Number of idle connections in the DB connection pool"),
+		inUseConnections:   desc("db_in_use_connections", "Number of in-use connections in the DB connection pool"),
+		maxOpenConnections: desc("db_max_open_connections", "Maximum number of open connections allowed in the DB connection pool"),
+		waitCount:          desc("db_wait_count_total", "Total number of connections waited for in the DB connection pool"),
+		waitDuration:       desc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection in the DB connection pool"),
+		maxIdleClosed:      desc("db_max_idle_closed_total", "Total number of connections closed due to max_idle_conns"),
+		maxIdleTimeClosed:  desc("db_max_idle_time_closed_total", "Total number of connections closed due to conn_idle_timeout"),
+		maxLifetimeClosed:  desc("db_max_lifetime_closed_total", "Total number of connections closed due to conn_max_lifetime"),
+	}
+}
+
+// Add starts reporting the pool of a target
+func (c *dbPoolCollector) Add(name string, db *sqlx.DB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[name] = db
+}
+
+// Remove stops reporting a pool
+func (c *dbPoolCollector) Remove(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pools, name)
+}
+
+// Describe implements prometheus.Collector
+func (c *dbPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.openConnections
+	ch <- c.idleConnections
+	ch <- c.inUseConnections
+	ch <- c.maxOpenConnections
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxIdleTimeClosed
+	ch <- c.maxLifetimeClosed
+}
+
+// Collect implements prometheus.Collector
+func (c *dbPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, db := range c.pools {
+		stats := db.Stats()
+		gauge := func(desc *prometheus.Desc, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, name)
+		}
+		counter := func(desc *prometheus.Desc, value float64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value, name)
+		}
+		gauge(c.openConnections, float64(stats.OpenConnections))
+		gauge(c.idleConnections, float64(stats.Idle))
+		gauge(c.inUseConnections, float64(stats.InUse))
+		gauge(c.maxOpenConnections, float64(stats.MaxOpenConnections))
+		counter(c.waitCount, float64(stats.WaitCount))
+		counter(c.waitDuration, stats.WaitDuration.Seconds())
+		counter(c.maxIdleClosed, float64(stats.MaxIdleClosed))
+		counter(c.maxIdleTimeClosed, float64(stats.MaxIdleTimeClosed))
+		counter(c.maxLifetimeClosed, float64(stats.MaxLifetimeClosed))
+	}
+}
+
 // Register metrics with prometheus
 func init() {
 	prometheus.MustRegister(queryErrors)
 	prometheus.MustRegister(queryDuration)
-	prometheus.MustRegister(openConnections)
-	prometheus.MustRegister(idleConnections)
-	prometheus.MustRegister(inUseConnections)
+	prometheus.MustRegister(poolCollector)
+	prometheus.MustRegister(connectPhaseDuration)
+	prometheus.MustRegister(churnDuration)
+	prometheus.MustRegister(missedDispatches)
+	prometheus.MustRegister(queriesInFlight)
+	prometheus.MustRegister(loadProfileStage)
+	prometheus.MustRegister(loadProfileTarget)
+	prometheus.MustRegister(idleProbes)
+	prometheus.MustRegister(backendQueries)
+	prometheus.MustRegister(backendSwitches)
+	prometheus.MustRegister(identityErrors)
+	prometheus.MustRegister(replicationLag)
+	prometheus.MustRegister(replicationLagDuration)
+	prometheus.MustRegister(secondsBehindSource)
+	prometheus.MustRegister(heartbeatErrors)
+	prometheus.MustRegister(splitStatements)
+	prometheus.MustRegister(splitViolations)
+	prometheus.MustRegister(rowsAffected)
+	prometheus.MustRegister(lastInsertID)
+	prometheus.MustRegister(transactionDuration)
+	prometheus.MustRegister(transactionLockErrors)
+	prometheus.MustRegister(statementModeDuration)
+	prometheus.MustRegister(statementStatus)
+	prometheus.MustRegister(seedRows)
+	prometheus.MustRegister(consistencyComparisons)
 }
 
 // This application isn't a web app, so start dedicated http server for prometheus
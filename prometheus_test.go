@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
 	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
@@ -14,9 +18,30 @@ import (
 func resetMetrics() {
 	queryErrors.Reset()
 	queryDuration.Reset()
-	openConnections.Reset()
-	idleConnections.Reset()
-	inUseConnections.Reset()
+	connectPhaseDuration.Reset()
+	churnDuration.Reset()
+	missedDispatches.Reset()
+	queriesInFlight.Reset()
+	loadProfileStage.Reset()
+	loadProfileTarget.Reset()
+	idleProbes.Reset()
+	backendQueries.Reset()
+	backendSwitches.Reset()
+	identityErrors.Reset()
+	replicationLag.Reset()
+	replicationLagDuration.Reset()
+	secondsBehindSource.Reset()
+	heartbeatErrors.Reset()
+	splitStatements.Reset()
+	splitViolations.Reset()
+	rowsAffected.Reset()
+	lastInsertID.Reset()
+	transactionDuration.Reset()
+	transactionLockErrors.Reset()
+	statementModeDuration.Reset()
+	statementStatus.Reset()
+	seedRows.Reset()
+	consistencyComparisons.Reset()
 }
 
 func TestQueryErrorsMetric(t *testing.T) {
@@ -25,10 +50,10 @@ func TestQueryErrorsMetric(t *testing.T) {
 	// Simulate an error
 	workerID := "1"
 	query := "test_query"
-	queryErrors.WithLabelValues(workerID, query).Inc()
+	queryErrors.WithLabelValues("primary", workerID, query, "execute", "1205", "HY000").Inc()
 
 	// Check that the metric value is incremented correctly
-	metricValue := testutil.ToFloat64(queryErrors.WithLabelValues(workerID, query))
+	metricValue := testutil.ToFloat64(queryErrors.WithLabelValues("primary", workerID, query, "execute", "1205", "HY000"))
 	if metricValue != 1 {
 		t.Errorf("Expected queryErrors metric to be 1, got %v", metricValue)
 	}
@@ -40,7 +65,7 @@ func TestQueryDurationMetric(t *testing.T) {
 	// Simulate recording a query duration
 	workerID := "2"
 	query := "test_duration_query"
-	queryDuration.WithLabelValues(workerID, query).Observe(2.5)
+	queryDuration.WithLabelValues("primary", workerID, query).Observe(2.5)
 
 	// Collect metrics for verification
 	collected := testutil.CollectAndCount(queryDuration, "db_query_duration_seconds")
@@ -53,7 +78,7 @@ func TestMetricsEndpoint(t *testing.T) {
 	resetMetrics() // Reset metrics before starting the test
 
 	// Increment the error metric to make sure it's present
-	queryErrors.WithLabelValues("1", "test_query").Inc()
+	queryErrors.WithLabelValues("primary", "1", "test_query", "execute", "1205", "HY000").Inc()
 
 	// Give the server some time to start
 	time.Sleep(1 * time.Second)
@@ -76,3 +101,84 @@ func TestMetricsEndpoint(t *testing.T) {
 		t.Errorf("Expected db_query_errors_total metric to be present")
 	}
 }
+
+func TestDBPoolCollector(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+
+	// Two workers sharing one pool still report a single series per pool
+	poolCollector.Add("primary", sqlx.NewDb(db, "mysql"))
+	poolCollector.Add("primary", sqlx.NewDb(db, "mysql"))
+	defer poolCollector.Remove("primary")
+
+	if count := testutil.CollectAndCount(poolCollector, "db_max_open_connections"); count != 1 {
+		t.Errorf("Expected 1 db_max_open_connections series, got %d", count)
+	}
+
+	expected := `
+# HELP db_max_open_connections Maximum number of open connections allowed in the DB connection pool
+# TYPE db_max_open_connections gauge
+db_max_open_connections{target="primary"} 7
+# HELP db_wait_count_total Total number of connections waited for in the DB connection pool
+# TYPE db_wait_count_total counter
+db_wait_count_total{target="primary"} 0
+`
+	if err := testutil.CollectAndCompare(poolCollector, strings.NewReader(expected), "db_max_open_connections", "db_wait_count_total"); err != nil {
+		t.Errorf("Unexpected pool metrics: %v", err)
+	}
+
+	// Removed pools are no longer reported
+	poolCollector.Remove("primary")
+	if count := testutil.CollectAndCount(poolCollector); count != 0 {
+		t.Errorf("Expected no pool metrics after removal, got %d", count)
+	}
+}
+
+func TestDBPoolCollectorCountsWaitsOnce(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	poolCollector.Add("primary", sqlx.NewDb(db, "mysql"))
+	defer poolCollector.Remove("primary")
+
+	// Three workers wait for the only connection of the shared pool
+	ctx := context.Background()
+	held, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get a connection: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if conn, err := db.Conn(ctx); err == nil {
+				conn.Close()
+			}
+		}()
+	}
+	for db.Stats().WaitCount < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	held.Close()
+	wg.Wait()
+
+	// The counter is the pool's own total, not a sum over workers or scrapes
+	expected := `
+# HELP db_wait_count_total Total number of connections waited for in the DB connection pool
+# TYPE db_wait_count_total counter
+db_wait_count_total{target="primary"} 3
+`
+	for scrape := 0; scrape < 2; scrape++ {
+		if err := testutil.CollectAndCompare(poolCollector, strings.NewReader(expected), "db_wait_count_total"); err != nil {
+			t.Errorf("Unexpected wait count on scrape %d: %v", scrape+1, err)
+		}
+	}
+}
@@ -1,3 +1,6 @@
 SELECT 1;
+-- name: current_user
 SELECT NOW(), current_user;
+-- name: hostname
+-- weight: 2
 SELECT @@hostname;
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checkSeedFile rejects seed files that are missing or in an unknown format
+func checkSeedFile(path string) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".jsonl", ".ndjson":
+	default:
+		return fmt.Errorf("seed file %s is neither .csv nor .jsonl", path)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error reading seed file: %w", err)
+	}
+	return nil
+}
+
+// loadSeedFile reads seed rows from a CSV file with a header line, or a JSONL file with one object per line.
+// The columns are in the order of the header, or of the keys of the first object.
+func loadSeedFile(path string) ([]string, [][]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading seed file: %w", err)
+	}
+	defer f.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".csv" {
+		return readSeedCSV(f)
+	}
+	return readSeedJSONL(f)
+}
+
+// readSeedCSV reads every record as a row of string values in the order of the header
+func readSeedCSV(r io.Reader) ([]string, [][]interface{}, error) {
+	reader := csv.NewReader(r)
+	columns, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("seed file has no header: %w", err)
+	}
+
+	var rows [][]interface{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return columns, rows, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("seed file: %w", err)
+		}
+		row := make([]interface{}, len(columns))
+		for i, value := range record {
+			row[i] = value
+		}
+		rows = append(rows, row)
+	}
+}
+
+// readSeedJSONL reads every non-empty line as a row. Every line must have the columns of the first one.
+func readSeedJSONL(r io.Reader) ([]string, [][]interface{}, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var columns []string
+	var rows [][]interface{}
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		keys, row, err := decodeSeedObject(scanner.Bytes())
+		if err != nil {
+			return nil, nil, fmt.Errorf("seed file line %d: %w", line, err)
+		}
+		if columns == nil {
+			columns = keys
+		}
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			value, ok := row[column]
+			if !ok {
+				return nil, nil, fmt.Errorf("seed file line %d has no column %q", line, column)
+			}
+			values[i] = value
+		}
+		rows = append(rows, values)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading seed file: %w", err)
+	}
+	return columns, rows, nil
+}
+
+// decodeSeedObject decodes a flat JSON object, keeping the order of its keys.
+// Whole numbers become int64 so they bind like the seed query's integer columns.
+func decodeSeedObject(data []byte) ([]string, map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, nil, fmt.Errorf("not a JSON object")
+	}
+
+	var keys []string
+	row := make(map[string]interface{})
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+		key := tok.(string)
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, nil, err
+		}
+		switch v := value.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				value = n
+			} else if value, err = v.Float64(); err != nil {
+				return nil, nil, err
+			}
+		case map[string]interface{}, []interface{}:
+			return nil, nil, fmt.Errorf("column %q is not a plain value", key)
+		}
+		if _, ok := row[key]; !ok {
+			keys = append(keys, key)
+		}
+		row[key] = value
+	}
+	return keys, row, nil
+}
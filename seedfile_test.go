@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSeedFile(t *testing.T) {
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "seeds.csv")
+	if err := os.WriteFile(csvFile, []byte("user_id,status\n7,open\n8,closed\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+	jsonlFile := filepath.Join(dir, "seeds.jsonl")
+	if err := os.WriteFile(jsonlFile, []byte("{\"user_id\": 7, \"status\": \"open\", \"score\": 1.5}\n\n{\"status\": \"closed\", \"user_id\": 8, \"score\": null}\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+
+	columns, rows, err := loadSeedFile(csvFile)
+	if err != nil {
+		t.Fatalf("Failed to load CSV seed file: %v", err)
+	}
+	if !reflect.DeepEqual(columns, []string{"user_id", "status"}) || len(rows) != 2 || rows[1][0] != "8" || rows[1][1] != "closed" {
+		t.Errorf("Unexpected CSV seed rows %v %v", columns, rows)
+	}
+
+	columns, rows, err = loadSeedFile(jsonlFile)
+	if err != nil {
+		t.Fatalf("Failed to load JSONL seed file: %v", err)
+	}
+	if !reflect.DeepEqual(columns, []string{"user_id", "status", "score"}) || len(rows) != 2 {
+		t.Fatalf("Unexpected JSONL seed rows %v %v", columns, rows)
+	}
+	if rows[0][0] != int64(7) || rows[0][2] != 1.5 || rows[1][0] != int64(8) || rows[1][2] != nil {
+		t.Errorf("Unexpected JSONL seed values %v", rows)
+	}
+
+	// Every line needs the columns of the first one, and only plain values
+	for name, content := range map[string]string{
+		"missing.jsonl": "{\"user_id\": 7, \"status\": \"open\"}\n{\"user_id\": 8}\n",
+		"nested.jsonl":  "{\"user_id\": {\"id\": 7}}\n",
+		"array.jsonl":   "[7, \"open\"]\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write seed file: %v", err)
+		}
+		if _, _, err := loadSeedFile(path); err == nil {
+			t.Errorf("Expected error loading %s", name)
+		}
+	}
+}
+
+func TestCheckSeedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "seeds.csv")
+	if err := os.WriteFile(path, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+	if err := checkSeedFile(path); err != nil {
+		t.Errorf("Expected seed file to pass: %v", err)
+	}
+	if err := checkSeedFile(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Errorf("Expected error for a missing seed file")
+	}
+	if err := checkSeedFile(filepath.Join(dir, "seeds.xml")); err == nil {
+		t.Errorf("Expected error for an unknown seed file format")
+	}
+}
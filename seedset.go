@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Retry delays of a failed seed load
+const (
+	seedRetryMin = 100 * time.Millisecond
+	seedRetryMax = 30 * time.Second
+)
+
+// defaultSeedMaxRows caps a paged seed load unless seed_max_rows is set.
+// Every worker of a scenario shares the rows, so this is enough keys to spread load over a large table.
+const defaultSeedMaxRows = 100000
+
+// seedSet holds the seed rows of one scenario on one target, shared by all of its workers.
+// It loads them in the background, retrying with backoff, and reloads them every refresh interval.
+type seedSet struct {
+	name    string
+	target  string
+	load    func() ([]string, [][]interface{}, error)
+	refresh time.Duration
+
+	mu      sync.RWMutex
+	columns []string        // In the order of the seed query or file
+	current [][]interface{} // Values in the order of columns
+	ready   chan struct{}   // Closed after the first successful load
+}
+
+// newStaticSeedSet returns a set that always holds rows
+func newStaticSeedSet(columns []string, rows [][]interface{}) *seedSet {
+	s := &seedSet{columns: columns, current: rows, ready: make(chan struct{})}
+	close(s.ready)
+	return s
+}
+
+// rows returns the seed columns and the current seed rows, nil until the first load
+func (s *seedSet) rows() ([]string, [][]interface{}) {
+	if s == nil {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.columns, s.current
+}
+
+// wait blocks until the first load succeeded or ctx is cancelled
+func (s *seedSet) wait(ctx context.Context) error {
+	select {
+	case <-s.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run loads the seed rows until ctx is cancelled. After the first load it only reloads with a refresh interval,
+// and a failed reload keeps the rows that were loaded before.
+func (s *seedSet) run(ctx context.Context, workerID int) {
+	for first := true; ; first = false {
+		if !first {
+			if s.refresh <= 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.refresh):
+			}
+		}
+
+		delay := seedRetryMin
+		for {
+			columns, rows, err := s.load()
+			if err == nil && len(rows) == 0 {
+				err = fmt.Errorf("no seed rows")
+			}
+			if err == nil {
+				s.mu.Lock()
+				s.columns, s.current = columns, rows
+				s.mu.Unlock()
+				seedRows.WithLabelValues(s.target, s.name).Set(float64(len(rows)))
+				if first {
+					close(s.ready)
+				}
+				break
+			}
+
+			recordQueryError(s.target, workerID, "seed_query", err)
+			log.Printf("Failed to load seed values of scenario %s on target %s, retrying in %v: %v", s.name, s.target, delay, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > seedRetryMax {
+				delay = seedRetryMax
+			}
+		}
+	}
+}
+
+// checkSeedSource rejects scenarios whose seed rows can't be loaded. A query template is bound to
+// seed rows, so it needs a seed query or file, and a seed query is read from a file or the database, not both.
+func checkSeedSource(sc *ScenarioConfig) error {
+	if sc.QueryTemplate != "" && sc.SeedQuery == "" && sc.SeedFile == "" {
+		return fmt.Errorf("query_template needs seed_query or seed_file")
+	}
+	if sc.SeedFile != "" {
+		if sc.SeedQuery != "" {
+			return fmt.Errorf("seed_query and seed_file can't be combined")
+		}
+		if err := checkSeedFile(sc.SeedFile); err != nil {
+			return err
+		}
+	}
+	if sc.SeedPageSize > 0 && sc.SeedStart == nil {
+		return fmt.Errorf("seed_page_size needs seed_start, the key the first page starts after")
+	}
+	return nil
+}
+
+// seedLoader returns a loader that reads the seed file of a scenario, or runs its seed query
+func seedLoader(target *Target, sc *ScenarioConfig) func() ([]string, [][]interface{}, error) {
+	if sc.SeedFile != "" {
+		return func() ([]string, [][]interface{}, error) {
+			return loadSeedFile(sc.SeedFile)
+		}
+	}
+	return seedQuery(target, sc)
+}
+
+// seedQuery returns a loader that runs the seed query of a scenario. With a page size it pages
+// through the result by the seed key: the query is passed the last key seen, seed_start for the first page,
+// and the page size, as in "SELECT id FROM users WHERE id > ? ORDER BY id LIMIT ?".
+// Paging stops after seed_max_rows rows.
+func seedQuery(target *Target, sc *ScenarioConfig) func() ([]string, [][]interface{}, error) {
+	if sc.SeedPageSize <= 0 {
+		return func() ([]string, [][]interface{}, error) {
+			return querySeedRows(target, sc.SeedQuery, nil)
+		}
+	}
+
+	key := sc.SeedKey
+	if key == "" {
+		key = "id"
+	}
+	maxRows := sc.SeedMaxRows
+	if maxRows <= 0 {
+		maxRows = defaultSeedMaxRows
+	}
+	return func() ([]string, [][]interface{}, error) {
+		var columns []string
+		var rows [][]interface{}
+		keyColumn := -1
+		last := sc.SeedStart
+		for {
+			limit := sc.SeedPageSize
+			if remaining := maxRows - len(rows); remaining < limit {
+				limit = remaining
+			}
+			pageColumns, page, err := querySeedRows(target, sc.SeedQuery, []interface{}{last, limit})
+			if err != nil {
+				return nil, nil, err
+			}
+			if columns == nil {
+				columns = pageColumns
+				if keyColumn = slices.Index(columns, key); keyColumn < 0 {
+					return nil, nil, fmt.Errorf("seed query doesn't return the seed key column %q", key)
+				}
+			}
+			rows = append(rows, page...)
+			if len(page) < limit {
+				return columns, rows, nil
+			}
+			if len(rows) >= maxRows {
+				log.Printf("Seed query of scenario %s on target %s stopped after %d rows", sc.label(), target.Name, len(rows))
+				return columns, rows, nil
+			}
+			last = page[len(page)-1][keyColumn]
+		}
+	}
+}
+
+// querySeedRows runs a seed query, keeping every row as a slice of values in column order
+func querySeedRows(target *Target, query string, values []interface{}) ([]string, [][]interface{}, error) {
+	ctx := context.Background()
+	conn, err := target.DB.Connx(ctx)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseConnect, err: err}
+	}
+	defer conn.Close()
+
+	runner, closeStmt, err := prepareStatement(ctx, paramRunner(conn, target.interpolates()), query, values)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closeStmt()
+	rows, err := runner.QueryxContext(ctx, query, values...)
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseExecute, err: err}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, &phaseError{phase: phaseRows, err: err}
+	}
+	var result [][]interface{}
+	for rows.Next() {
+		row, err := scanRow(rows, len(columns))
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, &phaseError{phase: phaseRows, err: err}
+	}
+	return columns, result, nil
+}
+
+// seedRegistry shares one seed set between the workers of a scenario on a target
+type seedRegistry struct {
+	ctx     context.Context // Stops loading and refreshing
+	mu      sync.Mutex
+	sets    map[[2]string]*seedSet // Keyed by target and scenario
+	loaders sync.WaitGroup
+}
+
+// seedSets is the registry of the current run
+var seedSets = newSeedRegistry(context.Background())
+
+func newSeedRegistry(ctx context.Context) *seedRegistry {
+	return &seedRegistry{ctx: ctx, sets: make(map[[2]string]*seedSet)}
+}
+
+// get returns the seed set of a scenario on a target, starting to load it on the first call
+func (r *seedRegistry) get(target *Target, sc *ScenarioConfig, workerID int) *seedSet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{target.Name, sc.label()}
+	if s, ok := r.sets[key]; ok {
+		return s
+	}
+	s := &seedSet{
+		name:    sc.label(),
+		target:  target.Name,
+		load:    seedLoader(target, sc),
+		refresh: sc.SeedRefresh,
+		ready:   make(chan struct{}),
+	}
+	r.sets[key] = s
+	r.loaders.Add(1)
+	go func() {
+		defer r.loaders.Done()
+		s.run(r.ctx, workerID)
+	}()
+	return s
+}
+
+// wait blocks until every seed set stopped loading, after the registry context was cancelled
+func (r *seedRegistry) wait() {
+	r.loaders.Wait()
+}
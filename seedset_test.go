@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestSeedSetRetryAndRefresh(t *testing.T) {
+	resetMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first two loads fail, then every load returns one more row than the last
+	var loads int64
+	s := &seedSet{
+		name:    "lookup",
+		target:  "primary",
+		refresh: 10 * time.Millisecond,
+		ready:   make(chan struct{}),
+		load: func() ([]string, [][]interface{}, error) {
+			n := atomic.AddInt64(&loads, 1)
+			if n <= 2 {
+				return nil, nil, errors.New("connection refused")
+			}
+			return []string{"id"}, make([][]interface{}, n-2), nil
+		},
+	}
+	go s.run(ctx, 1)
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer waitCancel()
+	if err := s.wait(waitCtx); err != nil {
+		t.Fatalf("Expected the seed set to load after retrying: %v", err)
+	}
+	if n := atomic.LoadInt64(&loads); n < 3 {
+		t.Errorf("Expected at least 3 loads, got %d", n)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, rows := s.rows()
+		if len(rows) >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the seed set to refresh, still %d rows", len(rows))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Waiting on a set that never loads gives up with the context
+	never := &seedSet{ready: make(chan struct{})}
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := never.wait(cancelled); err == nil {
+		t.Errorf("Expected wait to fail once the context is cancelled")
+	}
+}
+
+func TestSeedQueryPaged(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	target := &Target{Name: "primary", DB: sqlx.NewDb(db, "mysql")}
+	sc := &ScenarioConfig{SeedQuery: "SELECT id FROM users WHERE id > ? ORDER BY id LIMIT ?", SeedPageSize: 2, SeedStart: 0}
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(2, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4))
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(4, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+
+	columns, rows, err := seedQuery(target, sc)()
+	if err != nil {
+		t.Fatalf("Failed to page through seed query: %v", err)
+	}
+	if len(columns) != 1 || columns[0] != "id" || len(rows) != 5 || rows[4][0] != int64(5) {
+		t.Errorf("Expected 5 seed rows, got %v", rows)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	// Paging stops at the row limit, the last page is shortened to it
+	sc.SeedMaxRows = 3
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	if _, rows, err := seedQuery(target, sc)(); err != nil || len(rows) != 3 {
+		t.Errorf("Expected 3 seed rows, got %v: %v", rows, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	// Pages need the seed key to continue from
+	sc.SeedKey = "user_id"
+	sc.SeedMaxRows = 0
+	mock.ExpectPrepare("SELECT id FROM users").ExpectQuery().WithArgs(0, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	if _, _, err := seedQuery(target, sc)(); err == nil {
+		t.Errorf("Expected error for a missing seed key column")
+	}
+}
+
+func TestSeedRegistry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	registry := newSeedRegistry(ctx)
+	target := &Target{Name: "primary", DB: sqlx.NewDb(db, "mysql")}
+	first := registry.get(target, &ScenarioConfig{Name: "lookup", SeedQuery: "SELECT id FROM users"}, 1)
+	second := registry.get(target, &ScenarioConfig{Name: "lookup", SeedQuery: "SELECT id FROM users"}, 2)
+	other := registry.get(target, &ScenarioConfig{Name: "scan", SeedQuery: "SELECT id FROM users"}, 3)
+	if first != second {
+		t.Errorf("Expected the workers of a scenario to share their seed set")
+	}
+	if first == other {
+		t.Errorf("Expected scenarios to have their own seed sets")
+	}
+
+	// The loaders retry until the registry is cancelled
+	cancel()
+	registry.wait()
+}
+
+func TestCheckSeedSource(t *testing.T) {
+	seedFile := filepath.Join(t.TempDir(), "seeds.csv")
+	if err := os.WriteFile(seedFile, []byte("id\n1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		sc   ScenarioConfig
+		ok   bool
+	}{
+		{"template with seed query", ScenarioConfig{QueryTemplate: "SELECT ?", SeedQuery: "SELECT id FROM users"}, true},
+		{"template with seed file", ScenarioConfig{QueryTemplate: "SELECT ?", SeedFile: seedFile}, true},
+		{"no template", ScenarioConfig{}, true},
+		{"template without seeds", ScenarioConfig{QueryTemplate: "SELECT ?"}, false},
+		{"seed query and file", ScenarioConfig{SeedQuery: "SELECT id FROM users", SeedFile: seedFile}, false},
+		{"page size without start", ScenarioConfig{SeedQuery: "SELECT id FROM users", SeedPageSize: 10}, false},
+	}
+	for _, tt := range tests {
+		if err := checkSeedSource(&tt.sc); (err == nil) != tt.ok {
+			t.Errorf("%s: expected ok %v, got %v", tt.name, tt.ok, err)
+		}
+	}
+}
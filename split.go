@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// Statement kinds for read/write split verification
+const (
+	kindRead  = "read"
+	kindWrite = "write"
+)
+
+// Backend roles from @@read_only
+const (
+	backendWriter  = "writer"
+	backendReader  = "reader"
+	backendUnknown = "unknown"
+)
+
+// maxSplitSamples limits how many violations are kept for the summary
+const maxSplitSamples = 20
+
+// readOnlyErrorNumbers are the errors of a write refused by a read-only server
+var readOnlyErrorNumbers = map[uint16]bool{
+	1290: true, // ER_OPTION_PREVENTS_STATEMENT, --read-only
+	1792: true, // ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION
+	1836: true, // ER_READ_ONLY_MODE
+}
+
+// readKeywords start statements that read without changing anything
+var readKeywords = []string{"SELECT", "WITH", "SHOW", "DESCRIBE", "DESC ", "EXPLAIN"}
+
+// startsWithKeyword reports whether the first keyword of a statement is one of keywords
+func startsWithKeyword(sql string, keywords []string) bool {
+	upper := strings.ToUpper(strings.TrimLeft(sql, " \t\r\n("))
+	for _, keyword := range keywords {
+		if strings.HasPrefix(upper, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// statementKind guesses whether a statement is a read from its first keyword.
+// Locking reads must go to the writer and count as writes.
+func statementKind(sql string) string {
+	if !startsWithKeyword(sql, readKeywords) {
+		return kindWrite
+	}
+	upper := strings.ToUpper(sql)
+	if strings.Contains(upper, "FOR UPDATE") || strings.Contains(upper, "FOR SHARE") || strings.Contains(upper, "LOCK IN SHARE MODE") {
+		return kindWrite
+	}
+	return kindRead
+}
+
+// returnsRows reports whether a statement has a result set and must be queried rather than executed.
+// Stored procedures may return result sets, so CALL is queried too.
+func returnsRows(sql string) bool {
+	return startsWithKeyword(sql, readKeywords) || startsWithKeyword(sql, []string{"CALL", "VALUES", "TABLE"})
+}
+
+// isReadOnlyError reports whether a write was refused because the server is read-only
+func isReadOnlyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && readOnlyErrorNumbers[mysqlErr.Number]
+}
+
+// SplitViolation is one statement that landed on the wrong kind of backend
+type SplitViolation struct {
+	Time     time.Time `json:"time"`
+	Target   string    `json:"target"`
+	Scenario string    `json:"scenario"`
+	Query    string    `json:"query"`
+	Kind     string    `json:"kind"`
+	Backend  string    `json:"backend"`
+	Hostname string    `json:"hostname"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// SplitQuerySummary counts where the statements of one query landed on one target
+type SplitQuerySummary struct {
+	Target     string           `json:"target"`
+	Query      string           `json:"query"`
+	Kind       string           `json:"kind"`
+	Writer     int64            `json:"writer"`
+	Reader     int64            `json:"reader"`
+	Unknown    int64            `json:"unknown"`
+	Violations int64            `json:"violations"`
+	Hostnames  map[string]int64 `json:"hostnames"`
+}
+
+// SplitSummary is the read/write split report of the run
+type SplitSummary struct {
+	Queries    []SplitQuerySummary `json:"queries"`
+	Violations int64               `json:"violations"`
+	Samples    []SplitViolation    `json:"samples,omitempty"` // The first violations
+}
+
+// splitRecorder collects where tagged reads and writes landed
+type splitRecorder struct {
+	mu      sync.Mutex
+	queries map[[2]string]*SplitQuerySummary // Keyed by target and query name
+	samples []SplitViolation
+	total   int64
+}
+
+// splitChecks is the shared recorder fed by every scenario with verify_split enabled
+var splitChecks = newSplitRecorder()
+
+func newSplitRecorder() *splitRecorder {
+	return &splitRecorder{queries: make(map[[2]string]*SplitQuerySummary)}
+}
+
+// check returns a connection check that looks up the backend of every statement after it ran,
+// so the statement reaches the proxy unchanged and its lookup isn't timed. The statement and the lookup
+// share a transaction, read-only for reads, so that a proxy sends both to the same backend.
+// A write refused as read-only always counts as landing on a reader.
+func (r *splitRecorder) check(target, scenario string) connectionCheck {
+	return func(ctx context.Context, _ *sqlx.Conn, runner sqlx.QueryerContext, query *WeightedQuery, err error) {
+		kind := query.kind()
+		if err != nil {
+			if isReadOnlyError(err) {
+				_, hostname := lookupBackend(ctx, runner)
+				r.record(target, scenario, query.Name, kind, backendReader, hostname, err)
+			}
+			return
+		}
+		backend, hostname := lookupBackend(ctx, runner)
+		r.record(target, scenario, query.Name, kind, backend, hostname, nil)
+	}
+}
+
+// backendRole maps @@read_only to the role of the backend
+func backendRole(readOnly int) string {
+	if readOnly != 0 {
+		return backendReader
+	}
+	return backendWriter
+}
+
+// lookupBackend asks the backend of a connection or transaction for its role and hostname
+func lookupBackend(ctx context.Context, conn sqlx.QueryerContext) (string, string) {
+	var readOnly int
+	var hostname string
+	if err := conn.QueryRowxContext(ctx, "SELECT @@read_only, @@hostname").Scan(&readOnly, &hostname); err != nil {
+		return backendUnknown, ""
+	}
+	return backendRole(readOnly), hostname
+}
+
+// record counts where a statement landed and keeps a sample of the violations
+func (r *splitRecorder) record(target, scenario, query, kind, backend, hostname string, err error) {
+	violation := (kind == kindRead && backend == backendWriter) || (kind == kindWrite && backend == backendReader)
+	splitStatements.WithLabelValues(target, scenario, kind, backend).Inc()
+	if violation {
+		splitViolations.WithLabelValues(target, scenario, kind, hostname).Inc()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{target, query}
+	s, ok := r.queries[key]
+	if !ok {
+		s = &SplitQuerySummary{Target: target, Query: query, Kind: kind, Hostnames: make(map[string]int64)}
+		r.queries[key] = s
+	}
+	switch backend {
+	case backendWriter:
+		s.Writer++
+	case backendReader:
+		s.Reader++
+	default:
+		s.Unknown++
+	}
+	if hostname != "" {
+		s.Hostnames[hostname]++
+	}
+	if !violation {
+		return
+	}
+	s.Violations++
+	r.total++
+	if len(r.samples) < maxSplitSamples {
+		v := SplitViolation{Time: time.Now(), Target: target, Scenario: scenario, Query: query, Kind: kind, Backend: backend, Hostname: hostname}
+		if err != nil {
+			v.Error = err.Error()
+		}
+		r.samples = append(r.samples, v)
+	}
+}
+
+// Summary builds the report, or returns nil when no statements were checked
+func (r *splitRecorder) Summary() *SplitSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queries) == 0 {
+		return nil
+	}
+
+	summary := &SplitSummary{Violations: r.total, Samples: append([]SplitViolation(nil), r.samples...)}
+	for _, s := range r.queries {
+		summary.Queries = append(summary.Queries, *s)
+	}
+	sort.Slice(summary.Queries, func(i, j int) bool {
+		if summary.Queries[i].Target != summary.Queries[j].Target {
+			return summary.Queries[i].Target < summary.Queries[j].Target
+		}
+		return summary.Queries[i].Query < summary.Queries[j].Query
+	})
+	return summary
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStatementKind(t *testing.T) {
+	tests := map[string]string{
+		"SELECT * FROM users":                         kindRead,
+		"  (SELECT 1) UNION (SELECT 2)":               kindRead,
+		"with t as (select 1) select * from t":        kindRead,
+		"SHOW REPLICA STATUS":                         kindRead,
+		"SELECT * FROM users WHERE id = 1 FOR UPDATE": kindWrite,
+		"INSERT INTO users (name) VALUES (?)":         kindWrite,
+		"UPDATE users SET name = ? WHERE id = ?":      kindWrite,
+	}
+	for sql, expected := range tests {
+		if kind := statementKind(sql); kind != expected {
+			t.Errorf("Expected %q to be a %s, got %s", sql, expected, kind)
+		}
+	}
+}
+
+func TestSplitRecorder(t *testing.T) {
+	resetMetrics()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	// Two queries with the same text are still told apart by name
+	lookup := &WeightedQuery{Name: "lookup", SQL: "SELECT * FROM users WHERE id = ?"}
+	rename := &WeightedQuery{Name: "rename", SQL: "UPDATE users SET name = 'x' WHERE id = 1"}
+	renameAgain := &WeightedQuery{Name: "rename_again", SQL: rename.SQL}
+	recorder := newSplitRecorder()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+	run := &scenarioRun{target: "proxy", db: sqlxDB, check: recorder.check("proxy", "split"), checkInTx: true}
+	backendColumns := []string{"@@read_only", "@@hostname"}
+
+	// A read runs unchanged, then its backend is looked up in the same transaction. It landed on the writer.
+	mock.ExpectBegin()
+	mock.ExpectPrepare("^SELECT \\* FROM users WHERE id = \\?$").ExpectQuery().
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "id"}).AddRow(1, 1))
+	mock.ExpectQuery("SELECT @@read_only, @@hostname").WillReturnRows(sqlmock.NewRows(backendColumns).AddRow(0, "db1"))
+	mock.ExpectRollback()
+	runWorkloadQuery(run, 0, 0, lookup, []interface{}{1}, time.Now())
+
+	// A write on the writer is committed, one refused by a read-only reader is rolled back
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT @@read_only, @@hostname").WillReturnRows(sqlmock.NewRows(backendColumns).AddRow(0, "db1"))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnError(&mysql.MySQLError{Number: 1290, Message: "read-only"})
+	mock.ExpectQuery("SELECT @@read_only, @@hostname").WillReturnRows(sqlmock.NewRows(backendColumns).AddRow(1, "db2"))
+	mock.ExpectRollback()
+	runWorkloadQuery(run, 0, 0, rename, nil, time.Now())
+	runWorkloadQuery(run, 0, 0, renameAgain, nil, time.Now())
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	summary := recorder.Summary()
+	if summary.Violations != 2 || len(summary.Samples) != 2 || len(summary.Queries) != 3 {
+		t.Fatalf("Unexpected split summary: %+v", summary)
+	}
+	if q := summary.Queries[0]; q.Query != "lookup" || q.Kind != kindRead || q.Writer != 1 || q.Violations != 1 {
+		t.Errorf("Unexpected lookup summary: %+v", q)
+	}
+	if q := summary.Queries[1]; q.Query != "rename" || q.Writer != 1 || q.Violations != 0 {
+		t.Errorf("Unexpected rename summary: %+v", q)
+	}
+	if q := summary.Queries[2]; q.Query != "rename_again" || q.Reader != 1 || q.Violations != 1 || q.Hostnames["db2"] != 1 {
+		t.Errorf("Unexpected rename_again summary: %+v", q)
+	}
+	if summary.Samples[1].Error == "" || summary.Samples[1].Hostname != "db2" {
+		t.Errorf("Expected the refused write in the samples, got %+v", summary.Samples[1])
+	}
+	if value := testutil.ToFloat64(splitViolations.WithLabelValues("proxy", "split", kindWrite, "db2")); value != 1 {
+		t.Errorf("Expected one write violation on db2, got %v", value)
+	}
+}
+
+// splitProxy is a driver that routes like a read/write splitting proxy: outside a transaction
+// statements starting with SELECT go to the reader and the others to the writer, and a transaction
+// stays on the backend it started on, the reader for read-only ones
+type splitProxy struct{}
+
+func (splitProxy) Connect(context.Context) (driver.Conn, error) { return &splitProxyConn{}, nil }
+func (p splitProxy) Driver() driver.Driver                      { return nil }
+
+type splitProxyConn struct {
+	tx string // Backend of the open transaction
+}
+
+func (c *splitProxyConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+func (c *splitProxyConn) Close() error { return nil }
+func (c *splitProxyConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+func (c *splitProxyConn) Commit() error   { c.tx = ""; return nil }
+func (c *splitProxyConn) Rollback() error { c.tx = ""; return nil }
+
+func (c *splitProxyConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.tx = "writer"
+	if opts.ReadOnly {
+		c.tx = "reader"
+	}
+	return c, nil
+}
+
+func (c *splitProxyConn) backend(query string) string {
+	if c.tx != "" {
+		return c.tx
+	}
+	if strings.HasPrefix(query, "SELECT") {
+		return "reader"
+	}
+	return "writer"
+}
+
+func (c *splitProxyConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.backend(query)
+	return driver.RowsAffected(1), nil
+}
+
+// QueryContext answers every query with the role and hostname of the backend it was routed to
+func (c *splitProxyConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	backend := c.backend(query)
+	readOnly := int64(0)
+	if backend == "reader" {
+		readOnly = 1
+	}
+	return &splitProxyRows{row: []driver.Value{readOnly, backend}}, nil
+}
+
+type splitProxyRows struct {
+	row []driver.Value
+}
+
+func (r *splitProxyRows) Columns() []string { return []string{"@@read_only", "@@hostname"} }
+func (r *splitProxyRows) Close() error      { return nil }
+
+func (r *splitProxyRows) Next(dest []driver.Value) error {
+	if r.row == nil {
+		return io.EOF
+	}
+	copy(dest, r.row)
+	r.row = nil
+	return nil
+}
+
+func TestSplitRecorderThroughProxy(t *testing.T) {
+	resetMetrics()
+
+	db := sqlx.NewDb(sql.OpenDB(splitProxy{}), "mysql")
+	defer db.Close()
+	read := &WeightedQuery{Name: "read", SQL: "SELECT name FROM users WHERE id = 1"}
+	write := &WeightedQuery{Name: "write", SQL: "UPDATE users SET name = 'x' WHERE id = 1"}
+
+	// On its own the lookup of a write is a SELECT routed to the reader, so a correctly routed write looks wrong
+	recorder := newSplitRecorder()
+	run := &scenarioRun{target: "proxy", db: db, check: recorder.check("proxy", "split")}
+	runWorkloadQuery(run, 0, 0, write, nil, time.Now())
+	if summary := recorder.Summary(); summary.Violations != 1 {
+		t.Errorf("Expected the lookup outside the write's transaction to land on the reader, got %+v", summary)
+	}
+
+	// In one transaction the lookup lands where the statement did
+	recorder = newSplitRecorder()
+	run = &scenarioRun{target: "proxy", db: db, check: recorder.check("proxy", "split"), checkInTx: true}
+	runWorkloadQuery(run, 0, 0, write, nil, time.Now())
+	runWorkloadQuery(run, 0, 0, read, nil, time.Now())
+	summary := recorder.Summary()
+	if summary.Violations != 0 || len(summary.Queries) != 2 {
+		t.Fatalf("Expected no violations, got %+v", summary)
+	}
+	if q := summary.Queries[0]; q.Query != "read" || q.Reader != 1 {
+		t.Errorf("Expected the read on the reader, got %+v", q)
+	}
+	if q := summary.Queries[1]; q.Query != "write" || q.Writer != 1 {
+		t.Errorf("Expected the write on the writer, got %+v", q)
+	}
+}
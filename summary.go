@@ -0,0 +1,572 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Latencies are kept in log-scale buckets that each cover 1% of their value,
+// from 1µs up to well over an hour, so percentiles stay accurate without keeping every sample
+const (
+	histogramGrowth  = 1.01
+	histogramBuckets = 2400
+)
+
+// latencyHistogram collects the latencies and error count of one worker or query
+type latencyHistogram struct {
+	counts []int64
+	count  int64
+	errors int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int64, histogramBuckets)}
+}
+
+// bucketFor returns the bucket index of a latency
+func bucketFor(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	i := int(math.Log(us)/math.Log(histogramGrowth)) + 1
+	if i >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return i
+}
+
+// bucketUpperBound returns the largest latency that falls in a bucket
+func bucketUpperBound(i int) time.Duration {
+	return time.Duration(math.Pow(histogramGrowth, float64(i)) * float64(time.Microsecond))
+}
+
+// observe records a successful query latency
+func (h *latencyHistogram) observe(d time.Duration) {
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+	h.counts[bucketFor(d)]++
+	h.count++
+	h.sum += d
+}
+
+// merge adds the samples of another histogram
+func (h *latencyHistogram) merge(other *latencyHistogram) {
+	if other.count > 0 {
+		if h.count == 0 || other.min < h.min {
+			h.min = other.min
+		}
+		if other.max > h.max {
+			h.max = other.max
+		}
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.count += other.count
+	h.errors += other.errors
+	h.sum += other.sum
+}
+
+// percentile returns the latency below which the fraction p of the samples fall
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p * float64(h.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			// The bucket bound can overshoot the real samples at either end
+			upper := bucketUpperBound(i)
+			if upper > h.max {
+				return h.max
+			}
+			if upper < h.min {
+				return h.min
+			}
+			return upper
+		}
+	}
+	return h.max
+}
+
+// RunStats collects per-target, per-worker and per-query results for the end-of-run summary
+type RunStats struct {
+	mu         sync.Mutex
+	byTarget   map[string]*latencyHistogram
+	byWorker   map[string]*latencyHistogram
+	byQuery    map[string]*latencyHistogram
+	byMode     map[string]*latencyHistogram
+	total      int64
+	connectErr int64
+	errClasses map[string]int64
+	writes     map[[2]string]*WriteQuerySummary // Keyed by target and query name
+	maxQueries int64
+	limitHit   chan struct{}
+}
+
+// runStats is the shared recorder fed by every worker
+var runStats = NewRunStats(0)
+
+// NewRunStats creates a recorder that signals LimitReached after maxQueries queries, 0 for no limit
+func NewRunStats(maxQueries int64) *RunStats {
+	return &RunStats{
+		byTarget:   make(map[string]*latencyHistogram),
+		byWorker:   make(map[string]*latencyHistogram),
+		byQuery:    make(map[string]*latencyHistogram),
+		byMode:     make(map[string]*latencyHistogram),
+		errClasses: make(map[string]int64),
+		writes:     make(map[[2]string]*WriteQuerySummary),
+		maxQueries: maxQueries,
+		limitHit:   make(chan struct{}),
+	}
+}
+
+// Record adds one query execution against a target
+func (s *RunStats) Record(target string, workerID int, query string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, h := range []*latencyHistogram{
+		s.histogram(s.byTarget, target),
+		s.histogram(s.byWorker, strconv.Itoa(workerID)),
+		s.histogram(s.byQuery, query),
+	} {
+		if err != nil {
+			h.errors++
+		} else {
+			h.observe(d)
+		}
+	}
+
+	if err != nil {
+		s.errClasses[classifyError(err).String()]++
+		if isConnectError(err) {
+			s.connectErr++
+		}
+	}
+
+	s.total++
+	if s.maxQueries > 0 && s.total == s.maxQueries {
+		close(s.limitHit)
+	}
+}
+
+// RecordMode adds one query execution to the latencies of its statement mode
+func (s *RunStats) RecordMode(mode string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h := s.histogram(s.byMode, mode)
+	if err != nil {
+		h.errors++
+	} else {
+		h.observe(d)
+	}
+}
+
+// RecordWrite adds the rows affected and last insert ID of a statement executed against a target
+func (s *RunStats) RecordWrite(target, query string, affected, lastID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{target, query}
+	w, ok := s.writes[key]
+	if !ok {
+		w = &WriteQuerySummary{Target: target, Query: query}
+		s.writes[key] = w
+	}
+	w.Statements++
+	w.RowsAffected += affected
+	if lastID != 0 {
+		w.LastInsertID = lastID
+	}
+}
+
+// LimitReached is closed once max_queries queries have been recorded
+func (s *RunStats) LimitReached() <-chan struct{} {
+	return s.limitHit
+}
+
+func (s *RunStats) histogram(m map[string]*latencyHistogram, key string) *latencyHistogram {
+	h, ok := m[key]
+	if !ok {
+		h = newLatencyHistogram()
+		m[key] = h
+	}
+	return h
+}
+
+// LatencySummary is the result line of one target, worker or query. Latencies are in milliseconds.
+type LatencySummary struct {
+	Name       string  `json:"name"`
+	Count      int64   `json:"count"`
+	Errors     int64   `json:"errors"`
+	Min        float64 `json:"min_ms"`
+	Mean       float64 `json:"mean_ms"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+	P999       float64 `json:"p999_ms"`
+	Max        float64 `json:"max_ms"`
+	Throughput float64 `json:"throughput_qps"`
+}
+
+// WriteQuerySummary counts the rows changed by one statement on one target
+type WriteQuerySummary struct {
+	Target       string `json:"target"`
+	Query        string `json:"query"`
+	Statements   int64  `json:"statements"`
+	RowsAffected int64  `json:"rows_affected"`
+	LastInsertID int64  `json:"last_insert_id"` // 0 until a statement generated an ID
+}
+
+// PoolSummary holds the connection pool wait statistics over the run
+type PoolSummary struct {
+	MaxOpenConnections int     `json:"max_open_connections"`
+	OpenConnections    int     `json:"open_connections"`
+	WaitCount          int64   `json:"wait_count"`
+	WaitDuration       float64 `json:"wait_duration_ms"`
+	MeanWait           float64 `json:"mean_wait_ms"`
+	ConnectFailures    int64   `json:"connect_failures"`
+}
+
+// Summary is the end-of-run report
+type Summary struct {
+	Duration     float64              `json:"duration_seconds"`
+	Queries      int64                `json:"total_queries"`
+	Errors       int64                `json:"total_errors"`
+	Throughput   float64              `json:"throughput_qps"`
+	Total        LatencySummary       `json:"total"`
+	Targets      []LatencySummary     `json:"targets"`
+	Workers      []LatencySummary     `json:"workers"`
+	ByQuery      []LatencySummary     `json:"queries"`
+	Writes       []WriteQuerySummary  `json:"writes,omitempty"`
+	Transactions []TransactionSummary `json:"transactions,omitempty"`
+	Statements   *StatementSummary    `json:"statements,omitempty"`
+	Pool         PoolSummary          `json:"pool"`
+	ErrorClasses map[string]int64     `json:"error_classes,omitempty"`
+	Assertions   []AssertionResult    `json:"assertions,omitempty"`
+	IdleTest     []*IdleSummary       `json:"idle_test,omitempty"`
+	Identity     *IdentitySummary     `json:"identity,omitempty"`
+	Replication  []ReplicaLagSummary  `json:"replication,omitempty"`
+	Failover     *FailoverSummary     `json:"failover,omitempty"`
+	Split        *SplitSummary        `json:"split,omitempty"`
+	Consistency  *ConsistencySummary  `json:"consistency,omitempty"`
+}
+
+// Summary builds the report for a run that lasted elapsed
+func (s *RunStats) Summary(elapsed time.Duration, pool PoolSummary) *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := newLatencyHistogram()
+	for _, h := range s.byQuery {
+		total.merge(h)
+	}
+
+	summary := &Summary{
+		Duration: elapsed.Seconds(),
+		Total:    summarizeHistogram("total", total, elapsed),
+		Targets:  summarizeHistograms(s.byTarget, elapsed),
+		Workers:  summarizeHistograms(s.byWorker, elapsed),
+		ByQuery:  summarizeHistograms(s.byQuery, elapsed),
+		Pool:     pool,
+	}
+	summary.Pool.ConnectFailures = s.connectErr
+	if len(s.errClasses) > 0 {
+		summary.ErrorClasses = make(map[string]int64, len(s.errClasses))
+		for class, count := range s.errClasses {
+			summary.ErrorClasses[class] = count
+		}
+	}
+	if len(s.byMode) > 0 {
+		summary.Statements = &StatementSummary{Modes: summarizeHistograms(s.byMode, elapsed)}
+	}
+	for _, w := range s.writes {
+		summary.Writes = append(summary.Writes, *w)
+	}
+	sort.Slice(summary.Writes, func(i, j int) bool {
+		if summary.Writes[i].Target != summary.Writes[j].Target {
+			return summary.Writes[i].Target < summary.Writes[j].Target
+		}
+		return summary.Writes[i].Query < summary.Writes[j].Query
+	})
+	summary.Queries = summary.Total.Count
+	summary.Errors = summary.Total.Errors
+	summary.Throughput = summary.Total.Throughput
+	return summary
+}
+
+func summarizeHistograms(m map[string]*latencyHistogram, elapsed time.Duration) []LatencySummary {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	// Sort worker IDs numerically and query names alphabetically
+	sort.Slice(names, func(i, j int) bool {
+		a, errA := strconv.Atoi(names[i])
+		b, errB := strconv.Atoi(names[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+
+	result := make([]LatencySummary, 0, len(names))
+	for _, name := range names {
+		result = append(result, summarizeHistogram(name, m[name], elapsed))
+	}
+	return result
+}
+
+func summarizeHistogram(name string, h *latencyHistogram, elapsed time.Duration) LatencySummary {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	summary := LatencySummary{
+		Name:   name,
+		Count:  h.count + h.errors,
+		Errors: h.errors,
+		Min:    ms(h.min),
+		P50:    ms(h.percentile(0.50)),
+		P90:    ms(h.percentile(0.90)),
+		P99:    ms(h.percentile(0.99)),
+		P999:   ms(h.percentile(0.999)),
+		Max:    ms(h.max),
+	}
+	if h.count > 0 {
+		summary.Mean = ms(h.sum / time.Duration(h.count))
+	}
+	if elapsed > 0 {
+		summary.Throughput = float64(summary.Count) / elapsed.Seconds()
+	}
+	return summary
+}
+
+// poolSummary compares the pool statistics at the start and end of the run
+func poolSummary(start, end sql.DBStats) PoolSummary {
+	pool := PoolSummary{
+		MaxOpenConnections: end.MaxOpenConnections,
+		OpenConnections:    end.OpenConnections,
+		WaitCount:          end.WaitCount - start.WaitCount,
+		WaitDuration:       float64(end.WaitDuration-start.WaitDuration) / float64(time.Millisecond),
+	}
+	if pool.WaitCount > 0 {
+		pool.MeanWait = pool.WaitDuration / float64(pool.WaitCount)
+	}
+	return pool
+}
+
+// WriteSummary writes the report as "json" or as human-readable text
+func WriteSummary(w io.Writer, summary *Summary, format string) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(summary)
+	}
+
+	fmt.Fprintf(w, "\nRun summary: %d queries, %d errors in %.1fs (%.1f queries/s)\n",
+		summary.Queries, summary.Errors, summary.Duration, summary.Throughput)
+	fmt.Fprintf(w, "Pool: max open %d, open %d, wait count %d, wait duration %.1fms, mean wait %.3fms, connect failures %d\n",
+		summary.Pool.MaxOpenConnections, summary.Pool.OpenConnections, summary.Pool.WaitCount,
+		summary.Pool.WaitDuration, summary.Pool.MeanWait, summary.Pool.ConnectFailures)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	writeTable := func(title string, rows []LatencySummary) {
+		fmt.Fprintf(tw, "\n%s\tcount\terrors\tmin\tmean\tp50\tp90\tp99\tp999\tmax\tqps\t\n", title)
+		for _, r := range rows {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.1f\t\n",
+				r.Name, r.Count, r.Errors, r.Min, r.Mean, r.P50, r.P90, r.P99, r.P999, r.Max, r.Throughput)
+		}
+	}
+	writeTable("target", summary.Targets)
+	writeTable("worker", summary.Workers)
+	writeTable("query", append(summary.ByQuery, summary.Total))
+	if summary.Statements != nil {
+		writeTable("statement mode", summary.Statements.Modes)
+	}
+	fmt.Fprintln(tw, "\nLatencies in milliseconds")
+	if len(summary.Writes) > 0 {
+		fmt.Fprintf(tw, "\nwrite\ttarget\tstatements\trows affected\tlast insert id\t\n")
+		for _, w := range summary.Writes {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t\n", w.Query, w.Target, w.Statements, w.RowsAffected, w.LastInsertID)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(summary.ErrorClasses) > 0 {
+		fmt.Fprintln(w, "\nErrors by phase/code/sqlstate:")
+		classes := make([]string, 0, len(summary.ErrorClasses))
+		for class := range summary.ErrorClasses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "  %s: %d\n", class, summary.ErrorClasses[class])
+		}
+	}
+
+	if summary.Statements != nil && len(summary.Statements.Servers) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\nserver\tCom_stmt_prepare\tCom_stmt_execute\tCom_stmt_close\tPrepared_stmt_count\tmax_prepared_stmt_count\t\n")
+		for _, s := range summary.Statements.Servers {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t\n",
+				s.Target, s.ComStmtPrepare, s.ComStmtExecute, s.ComStmtClose, s.PreparedStmtCount, s.MaxPreparedStmtCount)
+		}
+		fmt.Fprintln(tw, "\nCom_stmt counters are the change over the run, server wide")
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(summary.Transactions) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\ntransaction\ttarget\tcount\tcommits\trollbacks\terrors\tdeadlocks\tlock waits\tmean\tp50\tp99\tmax\t\n")
+		for _, t := range summary.Transactions {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+				t.Scenario, t.Target, t.Count, t.Commits, t.Rollbacks, t.Errors, t.Deadlocks, t.LockWaitTimeouts, t.Mean, t.P50, t.P99, t.Max)
+		}
+		fmt.Fprintln(tw, "\nTransaction latencies in milliseconds, think time included")
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if summary.Identity != nil {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\nbackend\tserver_id\tqueries\tconnections\t\n")
+		for _, b := range summary.Identity.Backends {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t\n", b.Hostname, b.ServerID, b.Queries, b.Connections)
+		}
+		fmt.Fprintf(tw, "\nconnection\ttarget\tscenario\tqueries\tclients\tconnection_id\thostname\tserver_id\t\n")
+		for _, c := range summary.Identity.Connections {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%s\t%d\t\n",
+				c.Connection, c.Target, c.Scenario, c.Queries, c.Clients, c.ConnectionID, c.Hostname, c.ServerID)
+		}
+		if summary.Identity.Switches > 0 {
+			fmt.Fprintf(tw, "%d queries of a client connection were served by another backend connection than its last one\n", summary.Identity.Switches)
+		}
+		if summary.Identity.RetiredConnections > 0 {
+			fmt.Fprintf(tw, "%d connections closed or replaced during the run are counted in the backends only\n", summary.Identity.RetiredConnections)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(summary.Replication) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\nreplica\tsamples\terrors\tmean lag\tp50 lag\tp99 lag\tmax lag\tbehind source\tstatus errors\t\n")
+		for _, r := range summary.Replication {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%g\t%d\t\n",
+				r.Target, r.Samples, r.Errors, r.Mean, r.P50, r.P99, r.Max, r.SecondsBehindSource, r.StatusErrors)
+		}
+		fmt.Fprintln(tw, "\nLag in milliseconds, seconds behind source as last reported (-1 when not replicating)")
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if summary.Split != nil {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\nsplit\ttarget\tkind\twriter\treader\tunknown\tviolations\t\n")
+		for _, q := range summary.Split.Queries {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t\n", q.Query, q.Target, q.Kind, q.Writer, q.Reader, q.Unknown, q.Violations)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Read/write split violations: %d\n", summary.Split.Violations)
+		for _, v := range summary.Split.Samples {
+			fmt.Fprintf(w, "  %s %s %s on %s %s %s\n", v.Time.Format("15:04:05.000"), v.Target, v.Query, v.Backend, v.Hostname, v.Error)
+		}
+	}
+
+	if summary.Consistency != nil {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintf(tw, "\nconsistency\ttarget\tcomparisons\tdivergences\terrors\tdivergence rate\t\n")
+		for _, q := range summary.Consistency.Queries {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%.2f%%\t\n", q.Query, q.Target, q.Comparisons, q.Divergences, q.Errors, 100*q.DivergenceRate)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Divergences from %s: %d, comparisons skipped on reference errors: %d\n",
+			summary.Consistency.Reference, summary.Consistency.Divergences, summary.Consistency.ReferenceErrors)
+		for _, d := range summary.Consistency.Samples {
+			fmt.Fprintf(w, "  %s %s %s %s: %s\n", d.Time.Format("15:04:05.000"), d.Target, d.Query, d.Values, d.Diff)
+		}
+	}
+
+	if summary.Failover != nil {
+		fmt.Fprintf(w, "\nFailover timeline: %d outages\n", len(summary.Failover.Outages))
+		after := func(ms *float64) string {
+			if ms == nil {
+				return "never"
+			}
+			return fmt.Sprintf("%.0fms", *ms)
+		}
+		for _, o := range summary.Failover.Outages {
+			fmt.Fprintf(w, "  %s at %s: first error %s, %d errors, reconnect %s, backend change %s, baseline %s\n",
+				o.Target, o.Start.Format("15:04:05.000"), o.FirstError, o.Errors,
+				after(o.ReconnectAfter), after(o.BackendChange), after(o.BaselineAfter))
+			if o.BackendBefore != o.BackendAfter {
+				fmt.Fprintf(w, "    backend %s -> %s\n", o.BackendBefore, o.BackendAfter)
+			}
+		}
+	}
+
+	for _, idle := range summary.IdleTest {
+		fmt.Fprintf(w, "\nIdle connection survival on %s:\n", idle.Target)
+		for _, r := range idle.Intervals {
+			fmt.Fprintf(w, "  %s: %d alive, %d dead", r.Interval, r.Alive, r.Dead)
+			codes := make([]string, 0, len(r.Errors))
+			for code := range r.Errors {
+				codes = append(codes, code)
+			}
+			sort.Strings(codes)
+			for _, code := range codes {
+				fmt.Fprintf(w, " %s=%d", code, r.Errors[code])
+			}
+			fmt.Fprintln(w)
+		}
+		if idle.CutoffFound {
+			fmt.Fprintf(w, "  Suggested conn_idle_timeout: %s\n", idle.SuggestedTimeout)
+		} else {
+			fmt.Fprintln(w, "  No idle cutoff found in the tested intervals")
+		}
+	}
+
+	if len(summary.Assertions) > 0 {
+		fmt.Fprintln(w, "\nAssertions:")
+		for _, a := range summary.Assertions {
+			status := "PASS"
+			if !a.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "  %s  %s (actual %g)\n", status, a.Expression, a.Actual)
+		}
+	}
+	return nil
+}
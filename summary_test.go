@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramPercentile(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0.50, 500 * time.Millisecond},
+		{0.90, 900 * time.Millisecond},
+		{0.99, 990 * time.Millisecond},
+		{1.00, 1000 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got := h.percentile(tt.p)
+		// Buckets are 1% wide
+		if math.Abs(float64(got-tt.expected)) > 0.01*float64(tt.expected) {
+			t.Errorf("Expected p%v around %v, got %v", tt.p*100, tt.expected, got)
+		}
+	}
+	if h.min != time.Millisecond || h.max != time.Second {
+		t.Errorf("Unexpected min %v or max %v", h.min, h.max)
+	}
+}
+
+func TestRunStatsSummary(t *testing.T) {
+	stats := NewRunStats(4)
+	stats.Record("primary", 0, "lookup", 10*time.Millisecond, nil)
+	stats.Record("primary", 1, "lookup", 20*time.Millisecond, nil)
+	stats.Record("replica", 1, "scan", 30*time.Millisecond, nil)
+
+	select {
+	case <-stats.LimitReached():
+		t.Fatalf("Limit reached too early")
+	default:
+	}
+	stats.Record("primary", 1, "scan", time.Second, errors.New("lost connection"))
+	select {
+	case <-stats.LimitReached():
+	default:
+		t.Fatalf("Expected limit to be reached after 4 queries")
+	}
+
+	start := sql.DBStats{WaitCount: 2, WaitDuration: 10 * time.Millisecond}
+	end := sql.DBStats{MaxOpenConnections: 5, WaitCount: 6, WaitDuration: 30 * time.Millisecond}
+	summary := stats.Summary(2*time.Second, poolSummary(start, end))
+
+	if summary.Queries != 4 || summary.Errors != 1 || summary.Throughput != 2 {
+		t.Errorf("Unexpected totals: %+v", summary)
+	}
+	if len(summary.Targets) != 2 || summary.Targets[0].Name != "primary" || summary.Targets[0].Count != 3 {
+		t.Errorf("Unexpected target summaries: %+v", summary.Targets)
+	}
+	if len(summary.Workers) != 2 || summary.Workers[1].Name != "1" || summary.Workers[1].Count != 3 {
+		t.Errorf("Unexpected worker summaries: %+v", summary.Workers)
+	}
+	if len(summary.ByQuery) != 2 || summary.ByQuery[0].Name != "lookup" || summary.ByQuery[1].Errors != 1 {
+		t.Errorf("Unexpected query summaries: %+v", summary.ByQuery)
+	}
+	// Failed queries don't count towards latencies
+	if summary.ByQuery[1].Max != 30 {
+		t.Errorf("Expected scan max latency of 30ms, got %v", summary.ByQuery[1].Max)
+	}
+	if summary.Pool.WaitCount != 4 || summary.Pool.WaitDuration != 20 || summary.Pool.MeanWait != 5 {
+		t.Errorf("Unexpected pool summary: %+v", summary.Pool)
+	}
+
+	// JSON output round trips
+	var buf bytes.Buffer
+	if err := WriteSummary(&buf, summary, "json"); err != nil {
+		t.Fatalf("Failed to write json summary: %v", err)
+	}
+	var decoded Summary
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode json summary: %v", err)
+	}
+	if decoded.Queries != 4 || len(decoded.ByQuery) != 2 {
+		t.Errorf("Unexpected decoded summary: %+v", decoded)
+	}
+
+	// Text output lists every query
+	buf.Reset()
+	if err := WriteSummary(&buf, summary, "text"); err != nil {
+		t.Fatalf("Failed to write text summary: %v", err)
+	}
+	for _, expected := range []string{"Run summary: 4 queries, 1 errors", "lookup", "scan", "total", "wait count 4"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected text summary to contain %q:\n%s", expected, buf.String())
+		}
+	}
+}
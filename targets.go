@@ -0,0 +1,73 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Target is one named database the scenarios run against, with its own connection pool
+type Target struct {
+	Name   string
+	Config *DatabaseConfig
+	DB     *sqlx.DB
+}
+
+// checkTargets rejects duplicate target names and scenarios that name unknown targets
+func checkTargets(targets []DatabaseConfig, scenarios []ScenarioConfig) error {
+	names := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+		names[t.Name] = true
+	}
+	for _, sc := range scenarios {
+		for _, name := range sc.Targets {
+			if !names[name] {
+				return fmt.Errorf("scenario %s: unknown target %q", sc.label(), name)
+			}
+		}
+	}
+	return nil
+}
+
+// scenarioTargets returns the targets a scenario runs against, all of them when it names none
+func scenarioTargets(sc *ScenarioConfig, targets []*Target) []*Target {
+	if len(sc.Targets) == 0 {
+		return targets
+	}
+	var selected []*Target
+	for _, name := range sc.Targets {
+		for _, t := range targets {
+			if t.Name == name {
+				selected = append(selected, t)
+			}
+		}
+	}
+	return selected
+}
+
+// poolStats adds up the pool statistics of every target for the run summary
+func poolStats(targets []*Target) sql.DBStats {
+	var total sql.DBStats
+	for _, t := range targets {
+		stats := t.DB.Stats()
+		total.MaxOpenConnections += stats.MaxOpenConnections
+		total.OpenConnections += stats.OpenConnections
+		total.InUse += stats.InUse
+		total.Idle += stats.Idle
+		total.WaitCount += stats.WaitCount
+		total.WaitDuration += stats.WaitDuration
+		total.MaxIdleClosed += stats.MaxIdleClosed
+		total.MaxIdleTimeClosed += stats.MaxIdleTimeClosed
+		total.MaxLifetimeClosed += stats.MaxLifetimeClosed
+	}
+	return total
+}
+
+// interpolates reports whether the driver sends the target's statement parameters as text instead of preparing them
+func (t *Target) interpolates() bool {
+	return t.Config != nil && statementModeLabel(statementModePerQuery, t.Config.DSN) == statementModeInterpolated
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestScenarioTargets(t *testing.T) {
+	configs := []DatabaseConfig{{Name: "primary"}, {Name: "replica"}, {Name: "proxy"}}
+	targets := []*Target{{Name: "primary"}, {Name: "replica"}, {Name: "proxy"}}
+
+	// Scenarios without targets run against all of them
+	all := ScenarioConfig{Name: "all"}
+	if selected := scenarioTargets(&all, targets); len(selected) != 3 {
+		t.Errorf("Expected all targets, got %d", len(selected))
+	}
+
+	subset := ScenarioConfig{Name: "reads", Targets: []string{"replica", "proxy"}}
+	selected := scenarioTargets(&subset, targets)
+	if len(selected) != 2 || selected[0].Name != "replica" || selected[1].Name != "proxy" {
+		t.Errorf("Unexpected targets: %+v", selected)
+	}
+	if err := checkTargets(configs, []ScenarioConfig{all, subset}); err != nil {
+		t.Errorf("Expected valid targets, got %v", err)
+	}
+
+	// Unknown and duplicate names are rejected
+	unknown := ScenarioConfig{Name: "writes", Targets: []string{"writer"}}
+	if err := checkTargets(configs, []ScenarioConfig{unknown}); err == nil {
+		t.Errorf("Expected error for an unknown target")
+	}
+	if err := checkTargets(append(configs, DatabaseConfig{Name: "proxy"}), nil); err == nil {
+		t.Errorf("Expected error for a duplicate target name")
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FailoverConfig records the timeline of outages such as planned failovers:
+// the first failed query, every error class seen, the first successful query afterwards,
+// the backend change and the return of the error rate to its baseline.
+// An outage starts once errors clearly exceed the baseline, a stray error doesn't start one.
+type FailoverConfig struct {
+	ProbeInterval   time.Duration `yaml:"probe_interval"`    // How often @@hostname and @@server_id are checked, defaults to 1s
+	BaselineWindow  time.Duration `yaml:"baseline_window"`   // Error rate before an outage that counts as normal, defaults to 30s
+	RecoveryWindow  time.Duration `yaml:"recovery_window"`   // How long the error rate must stay at baseline to end an outage, defaults to 5s
+	OutageErrors    int           `yaml:"outage_errors"`     // Consecutive failed queries that start an outage, defaults to 3
+	OutageErrorRate float64       `yaml:"outage_error_rate"` // Or share of a second's queries, at least outage_errors of them, failing above the baseline, defaults to 0.5
+	EventLog        string        `yaml:"event_log"`         // Optional JSON lines file the events are appended to as they happen
+}
+
+// Timeline events
+const (
+	eventOutageStart      = "outage_start"
+	eventErrorClass       = "error_class"
+	eventReconnected      = "reconnected"
+	eventBackendChanged   = "backend_changed"
+	eventBaselineRestored = "baseline_restored"
+)
+
+// TimelineEvent is one entry of the event log
+type TimelineEvent struct {
+	Time       time.Time `json:"time"`
+	Target     string    `json:"target"`
+	Event      string    `json:"event"`
+	Detail     string    `json:"detail,omitempty"`
+	SinceStart float64   `json:"since_outage_start_ms"` // 0 outside of an outage
+}
+
+// classSeen is when an error class was first seen in an outage
+type classSeen struct {
+	class string
+	at    time.Time
+}
+
+// outage follows one outage of a target from its first failed query
+type outage struct {
+	start          time.Time
+	baseline       float64 // Error rate before the outage
+	backendBefore  string
+	backendAfter   string
+	firstClass     string
+	classes        map[string]int64
+	seen           []classSeen // Classes in the order they were first seen, reported once the outage starts
+	errors         int64
+	reconnected    time.Time
+	backendChanged time.Time
+	restored       time.Time
+}
+
+// timelineBucket counts the queries of one second
+type timelineBucket struct {
+	total  int64
+	errors int64
+}
+
+// targetTimeline is the outage state of one target
+type targetTimeline struct {
+	buckets     map[int64]*timelineBucket // Keyed by unix second
+	backend     string                    // Last probed hostname/server_id
+	consecutive int                       // Failed queries since the last successful one
+	pending     *outage                   // Errors that may yet turn out to be an outage
+	current     *outage
+	done        []*outage
+}
+
+// failoverTimeline records outages of every target.
+// A nil timeline ignores everything, so callers don't need to check whether it is enabled.
+type failoverTimeline struct {
+	cfg FailoverConfig
+
+	mu      sync.Mutex
+	targets map[string]*targetTimeline
+	events  []TimelineEvent
+	log     *json.Encoder
+}
+
+// timeline is the shared recorder fed by every worker, nil unless failover recording is enabled
+var timeline *failoverTimeline
+
+// newFailoverTimeline fills in the defaults. Events are also written to eventLog when it isn't nil.
+func newFailoverTimeline(cfg FailoverConfig, eventLog *os.File) *failoverTimeline {
+	if cfg.ProbeInterval <= 0 {
+		cfg.ProbeInterval = time.Second
+	}
+	if cfg.BaselineWindow <= 0 {
+		cfg.BaselineWindow = 30 * time.Second
+	}
+	if cfg.RecoveryWindow <= 0 {
+		cfg.RecoveryWindow = 5 * time.Second
+	}
+	if cfg.OutageErrors <= 0 {
+		cfg.OutageErrors = 3
+	}
+	if cfg.OutageErrorRate <= 0 {
+		cfg.OutageErrorRate = 0.5
+	}
+	t := &failoverTimeline{cfg: cfg, targets: make(map[string]*targetTimeline)}
+	if eventLog != nil {
+		t.log = json.NewEncoder(eventLog)
+	}
+	return t
+}
+
+func (t *failoverTimeline) target(name string) *targetTimeline {
+	tt, ok := t.targets[name]
+	if !ok {
+		tt = &targetTimeline{buckets: make(map[int64]*timelineBucket)}
+		t.targets[name] = tt
+	}
+	return tt
+}
+
+// event appends an event to the timeline and the event log
+func (t *failoverTimeline) event(now time.Time, target string, tt *targetTimeline, event, detail string) {
+	e := TimelineEvent{Time: now, Target: target, Event: event, Detail: detail}
+	if tt.current != nil {
+		e.SinceStart = float64(now.Sub(tt.current.start)) / float64(time.Millisecond)
+	}
+	t.events = append(t.events, e)
+	log.Printf("Failover timeline: %s %s %s (+%.0fms)", target, event, detail, e.SinceStart)
+	if t.log != nil {
+		if err := t.log.Encode(e); err != nil {
+			log.Printf("Failed to write failover event log: %v", err)
+			t.log = nil
+		}
+	}
+}
+
+// Record adds the outcome of one query against a target
+func (t *failoverTimeline) Record(target string, err error) {
+	if t == nil {
+		return
+	}
+	t.record(target, time.Now(), err)
+}
+
+func (t *failoverTimeline) record(target string, now time.Time, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tt := t.target(target)
+	b, ok := tt.buckets[now.Unix()]
+	if !ok {
+		b = &timelineBucket{}
+		tt.buckets[now.Unix()] = b
+	}
+	b.total++
+
+	// Errors that ended in an earlier second without starting an outage were not one
+	if o := tt.pending; o != nil && tt.consecutive == 0 && o.start.Unix() < now.Unix() {
+		tt.pending = nil
+	}
+
+	if err == nil {
+		tt.consecutive = 0
+		if o := tt.current; o != nil && o.reconnected.IsZero() {
+			o.reconnected = now
+			t.event(now, target, tt, eventReconnected, "")
+		}
+		return
+	}
+	b.errors++
+	tt.consecutive++
+
+	class := classifyError(err).String()
+	o := tt.current
+	if o == nil {
+		o = tt.pending
+	}
+	if o == nil {
+		// The baseline comes from the seconds before this one
+		from := now.Add(-t.cfg.BaselineWindow).Unix()
+		o = &outage{
+			start:         now,
+			baseline:      tt.errorRate(from, now.Unix()-1),
+			backendBefore: tt.backend,
+			firstClass:    class,
+			classes:       make(map[string]int64),
+		}
+		tt.pending = o
+	}
+	o.errors++
+	if o.classes[class] == 0 {
+		o.seen = append(o.seen, classSeen{class: class, at: now})
+		if o == tt.current {
+			t.event(now, target, tt, eventErrorClass, class)
+		}
+	}
+	o.classes[class]++
+
+	if o == tt.pending && t.outageStarted(tt, b, o.baseline) {
+		// Report the errors seen so far as of when they happened
+		tt.current, tt.pending = o, nil
+		t.event(o.start, target, tt, eventOutageStart, o.firstClass)
+		for _, seen := range o.seen {
+			t.event(seen.at, target, tt, eventErrorClass, seen.class)
+		}
+	}
+}
+
+// outageStarted reports whether the errors of a target are an outage: enough consecutive failures,
+// or enough of the current second's queries failing, while its error rate is above the baseline
+func (t *failoverTimeline) outageStarted(tt *targetTimeline, b *timelineBucket, baseline float64) bool {
+	rate := float64(b.errors) / float64(b.total)
+	baseline = math.Max(baseline, 0)
+	if rate <= baseline {
+		return false
+	}
+	return tt.consecutive >= t.cfg.OutageErrors ||
+		(b.errors >= int64(t.cfg.OutageErrors) && rate-baseline >= t.cfg.OutageErrorRate)
+}
+
+// errorRate returns the share of failed queries from second from through to, or -1 without queries
+func (tt *targetTimeline) errorRate(from, to int64) float64 {
+	var total, errors int64
+	for sec := from; sec <= to; sec++ {
+		if b, ok := tt.buckets[sec]; ok {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+	return float64(errors) / float64(total)
+}
+
+// tick ends the outages whose error rate has been back at baseline for the whole recovery window,
+// and drops buckets too old to matter
+func (t *failoverTimeline) tick(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	window := int64(t.cfg.RecoveryWindow / time.Second)
+	if window < 1 {
+		window = 1
+	}
+	to := now.Unix() - 1 // The current second isn't complete yet
+	from := to - window + 1
+
+	for name, tt := range t.targets {
+		if o := tt.current; o != nil && !o.reconnected.IsZero() && from > o.start.Unix() {
+			baseline := o.baseline
+			if baseline < 0 {
+				baseline = 0
+			}
+			if rate := tt.errorRate(from, to); rate >= 0 && rate <= baseline {
+				o.restored = time.Unix(from, 0)
+				o.backendAfter = tt.backend
+				t.event(now, name, tt, eventBaselineRestored, fmt.Sprintf("error rate %.4f", rate))
+				tt.done = append(tt.done, o)
+				tt.current = nil
+			}
+		}
+
+		oldest := now.Add(-t.cfg.BaselineWindow - t.cfg.RecoveryWindow).Unix()
+		for sec := range tt.buckets {
+			if sec < oldest {
+				delete(tt.buckets, sec)
+			}
+		}
+	}
+}
+
+// setBackend records the probed identity of a target's backend
+func (t *failoverTimeline) setBackend(target string, now time.Time, backend string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tt := t.target(target)
+	if tt.backend != "" && backend != tt.backend {
+		if o := tt.current; o != nil && o.backendChanged.IsZero() {
+			o.backendChanged = now
+		}
+		t.event(now, target, tt, eventBackendChanged, tt.backend+" -> "+backend)
+	}
+	tt.backend = backend
+}
+
+// RunFailoverTimeline probes the backend identity of every target and checks for recovery until ctx is cancelled
+func RunFailoverTimeline(ctx context.Context, t *failoverTimeline, targets []*Target) {
+	ticker := time.NewTicker(t.cfg.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		for _, target := range targets {
+			probeCtx, cancel := context.WithTimeout(ctx, t.cfg.ProbeInterval)
+			var hostname string
+			var serverID int64
+			err := target.DB.QueryRowxContext(probeCtx, "SELECT @@hostname, @@server_id").Scan(&hostname, &serverID)
+			cancel()
+			if err == nil {
+				t.setBackend(target.Name, time.Now(), fmt.Sprintf("%s/%d", hostname, serverID))
+			}
+		}
+		t.tick(time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// OutageSummary is the timeline of one outage. Times are in milliseconds from the first failed query
+// and left out when the outage ended, or the run stopped, before they happened.
+type OutageSummary struct {
+	Target         string           `json:"target"`
+	Start          time.Time        `json:"start"`
+	FirstError     string           `json:"first_error"`
+	Errors         int64            `json:"errors"`
+	ErrorClasses   map[string]int64 `json:"error_classes"`
+	ReconnectAfter *float64         `json:"reconnect_after_ms,omitempty"`
+	BackendChange  *float64         `json:"backend_change_after_ms,omitempty"`
+	BaselineAfter  *float64         `json:"baseline_after_ms,omitempty"`
+	BackendBefore  string           `json:"backend_before,omitempty"`
+	BackendAfter   string           `json:"backend_after,omitempty"`
+	BaselineRate   float64          `json:"baseline_error_rate"` // -1 when there were no queries before the outage
+	InProgress     bool             `json:"in_progress"`
+}
+
+// FailoverSummary is the event log and outage timelines of the run
+type FailoverSummary struct {
+	Outages []OutageSummary `json:"outages"`
+	Events  []TimelineEvent `json:"events"`
+}
+
+// Summary builds the report, or returns nil when the timeline is disabled
+func (t *failoverTimeline) Summary() *FailoverSummary {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	after := func(o *outage, at time.Time) *float64 {
+		if at.IsZero() {
+			return nil
+		}
+		ms := float64(at.Sub(o.start)) / float64(time.Millisecond)
+		return &ms
+	}
+	summary := &FailoverSummary{Events: append([]TimelineEvent(nil), t.events...)}
+	for name, tt := range t.targets {
+		outages := tt.done
+		if tt.current != nil {
+			outages = append(outages, tt.current)
+		}
+		for _, o := range outages {
+			backendAfter := o.backendAfter
+			if o == tt.current {
+				backendAfter = tt.backend
+			}
+			summary.Outages = append(summary.Outages, OutageSummary{
+				Target:         name,
+				Start:          o.start,
+				FirstError:     o.firstClass,
+				Errors:         o.errors,
+				ErrorClasses:   o.classes,
+				ReconnectAfter: after(o, o.reconnected),
+				BackendChange:  after(o, o.backendChanged),
+				BaselineAfter:  after(o, o.restored),
+				BackendBefore:  o.backendBefore,
+				BackendAfter:   backendAfter,
+				BaselineRate:   o.baseline,
+				InProgress:     o == tt.current,
+			})
+		}
+	}
+	sort.Slice(summary.Outages, func(i, j int) bool { return summary.Outages[i].Start.Before(summary.Outages[j].Start) })
+	return summary
+}
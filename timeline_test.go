@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestFailoverTimeline(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "events.jsonl")
+	eventLog, err := os.Create(logPath)
+	if err != nil {
+		t.Fatalf("Failed to create event log: %v", err)
+	}
+	defer eventLog.Close()
+
+	tl := newFailoverTimeline(FailoverConfig{RecoveryWindow: 2 * time.Second}, eventLog)
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	// Healthy traffic on the old primary
+	tl.setBackend("proxy", at(-5000), "db1/1")
+	for ms := -5000; ms < 0; ms += 500 {
+		tl.record("proxy", at(ms), nil)
+	}
+
+	// The failover: connections drop, then the server refuses new ones
+	tl.record("proxy", at(0), &phaseError{phase: phaseRows, err: mysql.ErrInvalidConn})
+	tl.record("proxy", at(100), &phaseError{phase: phaseConnect, err: &mysql.MySQLError{Number: 1040}})
+	tl.record("proxy", at(200), &phaseError{phase: phaseConnect, err: &mysql.MySQLError{Number: 1040}})
+	tl.record("proxy", at(1500), nil)
+	tl.setBackend("proxy", at(1600), "db2/2")
+	for ms := 2000; ms < 5000; ms += 500 {
+		tl.record("proxy", at(ms), nil)
+	}
+
+	// Not recovered until a whole window after the outage started is clean
+	tl.tick(at(2500))
+	if !tl.Summary().Outages[0].InProgress {
+		t.Fatalf("Expected the outage to be in progress")
+	}
+	tl.tick(at(4100))
+
+	summary := tl.Summary()
+	if len(summary.Outages) != 1 {
+		t.Fatalf("Expected one outage, got %+v", summary.Outages)
+	}
+	o := summary.Outages[0]
+	if o.InProgress || o.FirstError != "rows/invalid_conn" || o.Errors != 3 || o.ErrorClasses["connect/1040"] != 2 {
+		t.Errorf("Unexpected outage: %+v", o)
+	}
+	if o.ReconnectAfter == nil || *o.ReconnectAfter != 1500 {
+		t.Errorf("Expected reconnect after 1500ms, got %v", o.ReconnectAfter)
+	}
+	if o.BackendChange == nil || *o.BackendChange != 1600 {
+		t.Errorf("Expected backend change after 1600ms, got %v", o.BackendChange)
+	}
+	if o.BaselineAfter == nil || *o.BaselineAfter != 2000 {
+		t.Errorf("Expected baseline after 2000ms, got %v", o.BaselineAfter)
+	}
+	if o.BackendBefore != "db1/1" || o.BackendAfter != "db2/2" || o.BaselineRate != 0 {
+		t.Errorf("Unexpected backends or baseline: %+v", o)
+	}
+
+	// Every event is in the log in order
+	var events []string
+	for _, e := range summary.Events {
+		events = append(events, e.Event)
+	}
+	expected := []string{eventOutageStart, eventErrorClass, eventErrorClass, eventReconnected, eventBackendChanged, eventBaselineRestored}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected event %d to be %s, got %s", i, expected[i], events[i])
+		}
+	}
+	content, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read event log: %v", err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(content), []byte("\n"))
+	var first TimelineEvent
+	if len(lines) != len(expected) || json.Unmarshal(lines[0], &first) != nil || first.Detail != "rows/invalid_conn" {
+		t.Errorf("Unexpected event log: %s", content)
+	}
+}
+
+func TestFailoverTimelineThresholds(t *testing.T) {
+	tl := newFailoverTimeline(FailoverConfig{}, nil)
+	start := time.Unix(1000, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	refused := &phaseError{phase: phaseConnect, err: &mysql.MySQLError{Number: 1040}}
+
+	// Stray errors among healthy traffic, never three in a row
+	for ms := 0; ms < 3000; ms += 100 {
+		var err error
+		if ms%1000 == 0 || ms == 1100 {
+			err = refused
+		}
+		tl.record("primary", at(ms), err)
+	}
+	if outages := tl.Summary().Outages; len(outages) != 0 {
+		t.Fatalf("Expected stray errors not to start an outage, got %+v", outages)
+	}
+
+	// Two of every three queries fail, never three in a row, and the error rate starts the outage at the first of them
+	for i := 0; i < 10; i++ {
+		var err error
+		if i%3 != 2 {
+			err = refused
+		}
+		tl.record("primary", at(3000+50*i), err)
+	}
+	outages := tl.Summary().Outages
+	if len(outages) != 1 || !outages[0].Start.Equal(at(3000)) || outages[0].Errors != 7 || outages[0].FirstError != "connect/1040" {
+		t.Errorf("Expected one outage from the first failure at 3000ms, got %+v", outages)
+	}
+}
+
+func TestFailoverTimelineDisabled(t *testing.T) {
+	var tl *failoverTimeline
+	tl.Record("primary", errors.New("ignored"))
+	if tl.Summary() != nil {
+		t.Errorf("Expected no summary from a disabled timeline")
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/rand"
+)
+
+// TransactionConfig runs every iteration of a scenario as one transaction of several statements
+// on a single connection, instead of picking single statements from the workload mix
+type TransactionConfig struct {
+	Statements          []WeightedQuery `yaml:"statements"`           // Run in order, weights are ignored
+	IsolationLevel      string          `yaml:"isolation_level"`      // read_uncommitted, read_committed, repeatable_read or serializable, the server default when empty
+	RollbackProbability float64         `yaml:"rollback_probability"` // Share of transactions rolled back instead of committed
+	ThinkTime           time.Duration   `yaml:"think_time"`           // Pause between statements while the transaction stays open
+}
+
+// isolationLevels maps the configured isolation level to the one passed to BEGIN
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                 sql.LevelDefault,
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// MySQL errors caused by row locks held by other transactions
+const (
+	errLockWaitTimeout = 1205
+	errDeadlock        = 1213
+)
+
+// Transaction outcomes
+const (
+	outcomeCommit   = "commit"
+	outcomeRollback = "rollback"
+	outcomeError    = "error"
+)
+
+// validate checks the transaction before any worker starts
+func (tc *TransactionConfig) validate() error {
+	if len(tc.Statements) == 0 {
+		return fmt.Errorf("transaction has no statements")
+	}
+	if _, ok := isolationLevels[tc.IsolationLevel]; !ok {
+		return fmt.Errorf("unknown isolation level %q", tc.IsolationLevel)
+	}
+	if tc.RollbackProbability < 0 || tc.RollbackProbability > 1 {
+		return fmt.Errorf("rollback probability %g is not between 0 and 1", tc.RollbackProbability)
+	}
+	return nil
+}
+
+// workload builds the statements of the transaction in order. Statements without params
+// share one seed row per transaction when the scenario has a seed query or file.
+func (tc *TransactionConfig) workload(sc *ScenarioConfig) (*Workload, error) {
+	queries := make([]WeightedQuery, 0, len(tc.Statements))
+	for i, q := range tc.Statements {
+		if q.Name == "" {
+			q.Name = fmt.Sprintf("%s_statement_%d", transactionName(sc), i+1)
+		}
+		q.seeded = len(q.Params) == 0 && (sc.SeedQuery != "" || sc.SeedFile != "")
+		queries = append(queries, q)
+	}
+	return NewWorkload(queries)
+}
+
+// transactionName labels the transaction of a scenario and its BEGIN, COMMIT and ROLLBACK
+func transactionName(sc *ScenarioConfig) string {
+	if sc.Name == "" {
+		return "transaction"
+	}
+	return sc.Name + "_transaction"
+}
+
+// transaction runs the transaction of a scenario against a target's pool
+type transaction struct {
+	cfg        *TransactionConfig
+	db         *sqlx.DB
+	name       string
+	scenario   string
+	isolation  sql.IsolationLevel
+	statements []WeightedQuery
+}
+
+func newTransaction(sc *ScenarioConfig, db *sqlx.DB, workload *Workload) *transaction {
+	return &transaction{
+		cfg:        sc.Transaction,
+		db:         db,
+		name:       transactionName(sc),
+		scenario:   sc.label(),
+		isolation:  isolationLevels[sc.Transaction.IsolationLevel],
+		statements: workload.Queries(),
+	}
+}
+
+// transactionPlan holds the random choices of one transaction, made on the dispatching goroutine
+type transactionPlan struct {
+	values   [][]interface{}
+	rollback bool
+}
+
+// plan generates the values of every statement and decides whether the transaction will roll back
+func (t *transaction) plan(rng *rand.Rand, inputValues [][]interface{}, picker *seedPicker) transactionPlan {
+	p := transactionPlan{
+		values:   make([][]interface{}, len(t.statements)),
+		rollback: rng.Float64() < t.cfg.RollbackProbability,
+	}
+	var seedRow [][]interface{}
+	if len(inputValues) > 0 {
+		i := picker.pick(rng, len(inputValues))
+		seedRow = inputValues[i : i+1]
+	}
+	for i := range t.statements {
+		p.values[i] = seedValues(&t.statements[i], seedRow, rng, nil)
+	}
+	return p
+}
+
+// run executes one transaction and records its latency measured from startTime.
+// Every statement, BEGIN, COMMIT and ROLLBACK is also recorded as a query of its own.
+func (t *transaction) run(run *scenarioRun, workerID, i int, p transactionPlan, startTime time.Time) {
+	outcome, err := t.execute(run, workerID, i, p)
+	duration := time.Since(startTime)
+	transactionDuration.WithLabelValues(run.target, t.scenario, outcome).Observe(duration.Seconds())
+	transactions.record(run.target, t.scenario, outcome, duration, err)
+	if debug && err == nil {
+		log.Printf("[Worker %d - Query %d] Transaction %s finished with %s in %v", workerID, i, t.name, outcome, duration)
+	}
+}
+
+func (t *transaction) execute(run *scenarioRun, workerID, i int, p transactionPlan) (string, error) {
+	ctx := context.Background()
+	begin := &WeightedQuery{Name: t.name + "_begin", SQL: "BEGIN"}
+
+	start := time.Now()
+	conn, err := t.db.Connx(ctx)
+	if err != nil {
+		err = &phaseError{phase: phaseConnect, err: err}
+		recordWorkloadQuery(run, workerID, i, begin, nil, time.Since(start), err)
+		return outcomeError, err
+	}
+	defer conn.Close()
+
+	tx, err := conn.BeginTxx(ctx, &sql.TxOptions{Isolation: t.isolation})
+	recordWorkloadQuery(run, workerID, i, begin, nil, time.Since(start), err)
+	if err != nil {
+		return outcomeError, err
+	}
+
+	for n := range t.statements {
+		if n > 0 && t.cfg.ThinkTime > 0 {
+			time.Sleep(t.cfg.ThinkTime)
+		}
+		q := &t.statements[n]
+		start = time.Now()
+		columns, rows, err := runStatement(ctx, paramRunner(tx, run.interpolated), q.SQL, p.values[n])
+		duration := time.Since(start)
+		if err != nil {
+			recordWorkloadQuery(run, workerID, i, q, rows, duration, err)
+			// A deadlock has already rolled the transaction back, this only releases the connection
+			tx.Rollback()
+			return outcomeError, err
+		}
+		// A wrong result is recorded, but doesn't end the transaction
+		recordWorkloadQuery(run, workerID, i, q, rows, duration, validateResult(q, p.values[n], columns, rows))
+	}
+
+	outcome, end := outcomeCommit, tx.Commit
+	if p.rollback {
+		outcome, end = outcomeRollback, tx.Rollback
+	}
+	start = time.Now()
+	err = end()
+	recordWorkloadQuery(run, workerID, i, &WeightedQuery{Name: t.name + "_" + outcome, SQL: outcome}, nil, time.Since(start), err)
+	if err != nil {
+		return outcomeError, err
+	}
+	return outcome, nil
+}
+
+// lockError names errors caused by row locks, or returns "" for any other error
+func lockError(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return ""
+	}
+	switch mysqlErr.Number {
+	case errDeadlock:
+		return "deadlock"
+	case errLockWaitTimeout:
+		return "lock_wait_timeout"
+	}
+	return ""
+}
+
+// TransactionSummary is the result of the transactions of one scenario on one target.
+// Latencies are in milliseconds and cover committed and rolled back transactions, think time included.
+type TransactionSummary struct {
+	Target           string  `json:"target"`
+	Scenario         string  `json:"scenario"`
+	Count            int64   `json:"count"`
+	Commits          int64   `json:"commits"`
+	Rollbacks        int64   `json:"rollbacks"`
+	Errors           int64   `json:"errors"`
+	Deadlocks        int64   `json:"deadlocks"`
+	LockWaitTimeouts int64   `json:"lock_wait_timeouts"`
+	Mean             float64 `json:"mean_ms"`
+	P50              float64 `json:"p50_ms"`
+	P99              float64 `json:"p99_ms"`
+	Max              float64 `json:"max_ms"`
+}
+
+// transactionRecorder collects the transactions of every scenario
+type transactionRecorder struct {
+	mu        sync.Mutex
+	latencies map[[2]string]*latencyHistogram // Keyed by target and scenario
+	results   map[[2]string]*TransactionSummary
+}
+
+// transactions is the shared recorder fed by every scenario with a transaction
+var transactions = newTransactionRecorder()
+
+func newTransactionRecorder() *transactionRecorder {
+	return &transactionRecorder{
+		latencies: make(map[[2]string]*latencyHistogram),
+		results:   make(map[[2]string]*TransactionSummary),
+	}
+}
+
+// record adds the outcome of one transaction
+func (r *transactionRecorder) record(target, scenario, outcome string, d time.Duration, err error) {
+	lock := lockError(err)
+	if lock != "" {
+		transactionLockErrors.WithLabelValues(target, scenario, lock).Inc()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := [2]string{target, scenario}
+	s, ok := r.results[key]
+	if !ok {
+		s = &TransactionSummary{Target: target, Scenario: scenario}
+		r.results[key] = s
+		r.latencies[key] = newLatencyHistogram()
+	}
+	s.Count++
+	switch outcome {
+	case outcomeCommit:
+		s.Commits++
+	case outcomeRollback:
+		s.Rollbacks++
+	default:
+		s.Errors++
+	}
+	switch lock {
+	case "deadlock":
+		s.Deadlocks++
+	case "lock_wait_timeout":
+		s.LockWaitTimeouts++
+	}
+	if err == nil {
+		r.latencies[key].observe(d)
+	}
+}
+
+// Summary reports every scenario and target in order, or nil when no transactions ran
+func (r *transactionRecorder) Summary() []TransactionSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.results) == 0 {
+		return nil
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	result := make([]TransactionSummary, 0, len(r.results))
+	for key, s := range r.results {
+		h := r.latencies[key]
+		summary := *s
+		summary.P50 = ms(h.percentile(0.50))
+		summary.P99 = ms(h.percentile(0.99))
+		summary.Max = ms(h.max)
+		if h.count > 0 {
+			summary.Mean = ms(h.sum / time.Duration(h.count))
+		}
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Scenario != result[j].Scenario {
+			return result[i].Scenario < result[j].Scenario
+		}
+		return result[i].Target < result[j].Target
+	})
+	return result
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/exp/rand"
+)
+
+func TestTransactionConfigValidate(t *testing.T) {
+	statements := []WeightedQuery{{SQL: "SELECT 1"}}
+	tests := []struct {
+		tc    TransactionConfig
+		valid bool
+	}{
+		{TransactionConfig{Statements: statements}, true},
+		{TransactionConfig{Statements: statements, IsolationLevel: "serializable", RollbackProbability: 0.5}, true},
+		{TransactionConfig{}, false},
+		{TransactionConfig{Statements: statements, IsolationLevel: "snapshot"}, false},
+		{TransactionConfig{Statements: statements, RollbackProbability: 1.5}, false},
+	}
+	for _, test := range tests {
+		if err := test.tc.validate(); (err == nil) != test.valid {
+			t.Errorf("Expected valid=%v for %+v, got %v", test.valid, test.tc, err)
+		}
+	}
+}
+
+func TestTransactionRun(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+	transactions = newTransactionRecorder()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+
+	sc := &ScenarioConfig{
+		Name:      "transfer",
+		SeedQuery: "SELECT id FROM accounts",
+		Transaction: &TransactionConfig{
+			Statements: []WeightedQuery{
+				{SQL: "SELECT balance FROM accounts WHERE id = ? FOR UPDATE"},
+				{Name: "debit", SQL: "UPDATE accounts SET balance = balance - ? WHERE id = 1", Params: []string{"int:5:5"}},
+			},
+		},
+	}
+	workload, err := buildWorkload(sc)
+	if err != nil {
+		t.Fatalf("Failed to build transaction workload: %v", err)
+	}
+	if !workload.needsSeed() {
+		t.Errorf("Expected statements without params to need seed values")
+	}
+	tx := newTransaction(sc, sqlx.NewDb(db, "mysql"), workload)
+	run := &scenarioRun{target: "primary", workload: workload, transaction: tx, seeds: newStaticSeedSet([]string{"id"}, [][]interface{}{{7}})}
+	rng := rand.New(rand.NewSource(1))
+
+	// Committed
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT balance FROM accounts").ExpectQuery().WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(100))
+	mock.ExpectPrepare("UPDATE accounts").ExpectExec().WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	run.next(rng)(1, 1, time.Now())
+
+	// Rolled back as planned
+	tx.cfg.RollbackProbability = 1
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT balance FROM accounts").ExpectQuery().WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(95))
+	mock.ExpectPrepare("UPDATE accounts").ExpectExec().WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+	run.next(rng)(1, 2, time.Now())
+
+	// Deadlocked on the second statement
+	mock.ExpectBegin()
+	mock.ExpectPrepare("SELECT balance FROM accounts").ExpectQuery().WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(95))
+	mock.ExpectPrepare("UPDATE accounts").ExpectExec().WillReturnError(&mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found"})
+	mock.ExpectRollback()
+	run.next(rng)(1, 3, time.Now())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unmet expectations: %v", err)
+	}
+
+	summaries := transactions.Summary()
+	if len(summaries) != 1 {
+		t.Fatalf("Expected one transaction summary, got %+v", summaries)
+	}
+	s := summaries[0]
+	if s.Target != "primary" || s.Scenario != "transfer" || s.Count != 3 || s.Commits != 1 || s.Rollbacks != 1 || s.Errors != 1 || s.Deadlocks != 1 {
+		t.Errorf("Unexpected transaction summary: %+v", s)
+	}
+	if v := testutil.ToFloat64(transactionLockErrors.WithLabelValues("primary", "transfer", "deadlock")); v != 1 {
+		t.Errorf("Expected 1 deadlock, got %v", v)
+	}
+
+	// Every statement is also reported as a query of its own
+	counts := make(map[string]int64)
+	for _, q := range runStats.Summary(time.Second, PoolSummary{}).ByQuery {
+		counts[q.Name] = q.Count
+	}
+	expected := map[string]int64{
+		"transfer_transaction_begin":       3,
+		"transfer_transaction_statement_1": 3,
+		"debit":                            3,
+		"transfer_transaction_commit":      1,
+		"transfer_transaction_rollback":    1,
+	}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("Expected %d executions of %s, got %d", count, name, counts[name])
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Result checks, the error code of a mismatch
+const (
+	checkRowCount = "row_count"
+	checkColumn   = "column_value"
+	checkChecksum = "checksum"
+)
+
+// checksumStable compares every result of a statement with its first result for the same values
+const checksumStable = "stable"
+
+// maxStableChecksums bounds the results remembered for stable checksums, later values go unchecked
+const maxStableChecksums = 100000
+
+// checksumSize is the number of bytes of a result checksum
+const checksumSize = 8
+
+// ResultExpectation declares what a statement must return.
+// Statements without a result set count the rows they affected instead of rows returned.
+type ResultExpectation struct {
+	MinRows  *int              `yaml:"min_rows"`
+	MaxRows  *int              `yaml:"max_rows"`
+	NonEmpty bool              `yaml:"non_empty"`
+	Columns  map[string]string `yaml:"columns"`  // Value every row must have in the column, compared as text, NULL for NULL
+	Checksum string            `yaml:"checksum"` // Checksum of the whole result, or "stable" for the same result on every execution with the same values on any target
+}
+
+// validate checks the expectation before any statement runs
+func (e *ResultExpectation) validate() error {
+	if (e.MinRows != nil && *e.MinRows < 0) || (e.MaxRows != nil && *e.MaxRows < 0) {
+		return fmt.Errorf("expected row counts can't be negative")
+	}
+	if e.MinRows != nil && e.MaxRows != nil && *e.MinRows > *e.MaxRows {
+		return fmt.Errorf("min_rows %d is larger than max_rows %d", *e.MinRows, *e.MaxRows)
+	}
+	if e.Checksum != "" && e.Checksum != checksumStable {
+		if b, err := hex.DecodeString(e.Checksum); err != nil || len(b) != checksumSize {
+			return fmt.Errorf("checksum %q is neither %q nor %d hex digits", e.Checksum, checksumStable, 2*checksumSize)
+		}
+	}
+	return nil
+}
+
+// resultMismatch is a result that doesn't meet the statement's expectation
+type resultMismatch struct {
+	check  string
+	detail string
+}
+
+func (e *resultMismatch) Error() string {
+	return fmt.Sprintf("result mismatch: %s", e.detail)
+}
+
+// validateResult compares the result of a statement with its expectation, if it has one.
+// Mismatches are returned as validate phase errors.
+func validateResult(query *WeightedQuery, values []interface{}, columns []string, rows []map[string]interface{}) error {
+	e := query.Expect
+	if e == nil {
+		return nil
+	}
+	mismatch := func(check, format string, args ...interface{}) error {
+		return &phaseError{phase: phaseValidate, err: &resultMismatch{check: check, detail: fmt.Sprintf(format, args...)}}
+	}
+
+	count := len(rows)
+	if !returnsRows(query.SQL) && len(rows) == 1 {
+		affected, _ := rows[0]["rows_affected"].(int64)
+		count = int(affected)
+	}
+	if e.NonEmpty && count == 0 {
+		return mismatch(checkRowCount, "no rows, expected some")
+	}
+	if e.MinRows != nil && count < *e.MinRows {
+		return mismatch(checkRowCount, "%d rows, expected at least %d", count, *e.MinRows)
+	}
+	if e.MaxRows != nil && count > *e.MaxRows {
+		return mismatch(checkRowCount, "%d rows, expected at most %d", count, *e.MaxRows)
+	}
+
+	for _, row := range rows {
+		for column, expected := range e.Columns {
+			value, ok := row[column]
+			if !ok {
+				return mismatch(checkColumn, "no column %s", column)
+			}
+			if actual := resultValue(value); actual != expected {
+				return mismatch(checkColumn, "column %s is %q, expected %q", column, actual, expected)
+			}
+		}
+	}
+
+	if e.Checksum == "" {
+		return nil
+	}
+	checksum := resultChecksum(columns, rows)
+	expected := e.Checksum
+	if expected == checksumStable {
+		expected = checksums.first(fmt.Sprintf("%s %v", query.Name, values), checksum)
+	}
+	if checksum != expected {
+		return mismatch(checkChecksum, "checksum %s, expected %s", checksum, expected)
+	}
+	return nil
+}
+
+// resultValue formats a scanned value as text, the way the server sends it without parseTime
+func resultValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// resultChecksum hashes a result set in column and row order. Values are hashed as text,
+// so the same data matches whether the driver scanned it as a number or a string.
+func resultChecksum(columns []string, rows []map[string]interface{}) string {
+	h := sha256.New()
+	for _, column := range columns {
+		fmt.Fprintf(h, "%d:%s;", len(column), column)
+	}
+	for _, row := range rows {
+		h.Write([]byte{'\n'})
+		io.WriteString(h, rowKey(columns, row))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:checksumSize])
+}
+
+// rowKey encodes the values of a row in column order, telling NULL apart from every string
+func rowKey(columns []string, row map[string]interface{}) string {
+	var b strings.Builder
+	for _, column := range columns {
+		value, ok := row[column]
+		if !ok || value == nil {
+			b.WriteString("-;")
+			continue
+		}
+		s := resultValue(value)
+		fmt.Fprintf(&b, "%d:%s;", len(s), s)
+	}
+	return b.String()
+}
+
+// checksumRecorder remembers the first result checksum of every statement and its values
+type checksumRecorder struct {
+	mu     sync.Mutex
+	checks map[string]string
+}
+
+// checksums is the shared recorder of the current run
+var checksums = newChecksumRecorder()
+
+func newChecksumRecorder() *checksumRecorder {
+	return &checksumRecorder{checks: make(map[string]string)}
+}
+
+// first returns the checksum first seen for key, remembering checksum when key is new
+func (r *checksumRecorder) first(key, checksum string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if first, ok := r.checks[key]; ok {
+		return first
+	}
+	if len(r.checks) < maxStableChecksums {
+		r.checks[key] = checksum
+	}
+	return checksum
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResultExpectationValidate(t *testing.T) {
+	one, two := 1, 2
+	tests := []struct {
+		e     ResultExpectation
+		valid bool
+	}{
+		{ResultExpectation{MinRows: &one, MaxRows: &two, Checksum: checksumStable}, true},
+		{ResultExpectation{Checksum: "00112233aabbccdd"}, true},
+		{ResultExpectation{MinRows: &two, MaxRows: &one}, false},
+		{ResultExpectation{Checksum: "abc"}, false},
+	}
+	for _, test := range tests {
+		if err := test.e.validate(); (err == nil) != test.valid {
+			t.Errorf("Expected valid=%v for %+v, got %v", test.valid, test.e, err)
+		}
+	}
+}
+
+func TestValidateResult(t *testing.T) {
+	checksums = newChecksumRecorder()
+	one := 1
+	columns := []string{"id", "status"}
+	rows := []map[string]interface{}{{"id": int64(7), "status": "active"}}
+
+	mismatch := func(err error) string {
+		var m *resultMismatch
+		if !errors.As(err, &m) {
+			return ""
+		}
+		return m.check
+	}
+	tests := []struct {
+		query    WeightedQuery
+		rows     []map[string]interface{}
+		expected string
+	}{
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{NonEmpty: true, MaxRows: &one}}, rows, ""},
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{NonEmpty: true}}, nil, checkRowCount},
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{Columns: map[string]string{"id": "7", "status": "active"}}}, rows, ""},
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{Columns: map[string]string{"status": "deleted"}}}, rows, checkColumn},
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{Checksum: resultChecksum(columns, rows)}}, rows, ""},
+		{WeightedQuery{SQL: "SELECT id, status FROM users", Expect: &ResultExpectation{Checksum: "00112233aabbccdd"}}, rows, checkChecksum},
+		// Statements without a result set count the rows they affected
+		{WeightedQuery{SQL: "UPDATE users SET status = 'active'", Expect: &ResultExpectation{MinRows: &one}}, []map[string]interface{}{{"rows_affected": int64(0), "last_insert_id": int64(0)}}, checkRowCount},
+	}
+	for _, test := range tests {
+		err := validateResult(&test.query, nil, columns, test.rows)
+		if check := mismatch(err); check != test.expected {
+			t.Errorf("Expected mismatch %q for %+v, got %v", test.expected, test.query.Expect, err)
+		}
+	}
+
+	// Stable checksums compare executions with the same values, on any target
+	query := &WeightedQuery{Name: "lookup", SQL: "SELECT id, status FROM users WHERE id = ?", Expect: &ResultExpectation{Checksum: checksumStable}}
+	if err := validateResult(query, []interface{}{7}, columns, rows); err != nil {
+		t.Errorf("Expected the first result to set the checksum: %v", err)
+	}
+	if err := validateResult(query, []interface{}{8}, columns, []map[string]interface{}{{"id": int64(8), "status": "active"}}); err != nil {
+		t.Errorf("Expected other values to have their own checksum: %v", err)
+	}
+	if err := validateResult(query, []interface{}{7}, columns, []map[string]interface{}{{"id": int64(7), "status": "deleted"}}); mismatch(err) != checkChecksum {
+		t.Errorf("Expected a checksum mismatch for a changed result, got %v", err)
+	}
+}
+
+func TestResultChecksum(t *testing.T) {
+	columns := []string{"id", "name"}
+	a := resultChecksum(columns, []map[string]interface{}{{"id": int64(1), "name": "alice"}})
+	b := resultChecksum(columns, []map[string]interface{}{{"id": "1", "name": "alice"}})
+	if a != b {
+		t.Errorf("Expected the same checksum whether values are scanned as numbers or text, got %s and %s", a, b)
+	}
+	if c := resultChecksum(columns, []map[string]interface{}{{"id": int64(1), "name": nil}}); c == a {
+		t.Errorf("Expected NULL to change the checksum")
+	}
+	if c := resultChecksum(columns, []map[string]interface{}{{"id": int64(1), "name": "NULL"}}); c == resultChecksum(columns, []map[string]interface{}{{"id": int64(1), "name": nil}}) {
+		t.Errorf("Expected NULL and the string NULL to have different checksums")
+	}
+	if len(a) != 2*checksumSize {
+		t.Errorf("Expected a checksum of %d hex digits, got %s", 2*checksumSize, a)
+	}
+}
+
+func TestRunWorkloadQueryMismatch(t *testing.T) {
+	resetMetrics()
+	runStats = NewRunStats(0)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to open sqlmock database: %v", err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "mysql")
+
+	query := &WeightedQuery{Name: "count", SQL: "SELECT COUNT(*) AS n FROM users", Expect: &ResultExpectation{Columns: map[string]string{"n": "10"}}}
+	run := &scenarioRun{
+		target: "replica",
+		runQuery: func(query string, values []interface{}) ([]string, []map[string]interface{}, error) {
+			return genericQuery(&Target{DB: sqlxDB}, query, values)
+		},
+	}
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(9))
+	runWorkloadQuery(run, 1, 1, query, nil, time.Now())
+
+	if v := testutil.ToFloat64(queryErrors.WithLabelValues("replica", "1", "count", phaseValidate, checkColumn, "")); v != 1 {
+		t.Errorf("Expected 1 validate error, got %v", v)
+	}
+	if classes := runStats.Summary(time.Second, PoolSummary{}).ErrorClasses; classes["validate/column_value"] != 1 {
+		t.Errorf("Expected the mismatch in the summary's error classes, got %v", classes)
+	}
+}
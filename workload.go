@@ -0,0 +1,302 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/exp/rand"
+)
+
+// WeightedQuery is a single statement in the workload mix
+type WeightedQuery struct {
+	Name   string             `yaml:"name"`
+	SQL    string             `yaml:"sql"`
+	Weight int                `yaml:"weight"`
+	Kind   string             `yaml:"kind"`   // "read" or "write", guessed from the statement when empty
+	Params []string           `yaml:"params"` // Generators of the ? placeholders in order, see parseGenerator
+	Expect *ResultExpectation `yaml:"expect"` // Checks of the result, mismatches are counted as validate errors
+
+	// seeded marks statements that are bound to values from the seed query or file
+	seeded bool
+	// columns are the indexes of the seed columns bound to the placeholders of statements written with :name placeholders
+	columns []int
+	// generators produce the values of statements with params
+	generators []valueGenerator
+}
+
+// generate returns a fresh value for every parameter
+func (q *WeightedQuery) generate(rng *rand.Rand) []interface{} {
+	values := make([]interface{}, len(q.generators))
+	for i, generator := range q.generators {
+		values[i] = generator(rng)
+	}
+	return values
+}
+
+// kind returns whether the statement should be routed to a reader or the writer
+func (q *WeightedQuery) kind() string {
+	if q.Kind != "" {
+		return q.Kind
+	}
+	return statementKind(q.SQL)
+}
+
+// UnmarshalYAML accepts either a plain SQL string or a name/sql/weight/kind/params/expect mapping
+func (q *WeightedQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var sql string
+	if err := unmarshal(&sql); err == nil {
+		q.SQL = sql
+		return nil
+	}
+
+	type plain WeightedQuery
+	var p plain
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*q = WeightedQuery(p)
+	return nil
+}
+
+// Workload picks statements by weighted random selection
+type Workload struct {
+	queries []WeightedQuery
+	total   int
+}
+
+// NewWorkload validates the weights and builds the selection table
+func NewWorkload(queries []WeightedQuery) (*Workload, error) {
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("workload has no queries")
+	}
+
+	w := &Workload{}
+	for _, q := range queries {
+		if q.SQL == "" {
+			return nil, fmt.Errorf("query %q has no SQL", q.Name)
+		}
+		if q.Weight < 0 {
+			return nil, fmt.Errorf("query %q has negative weight %d", q.Name, q.Weight)
+		}
+		if q.Kind != "" && q.Kind != kindRead && q.Kind != kindWrite {
+			return nil, fmt.Errorf("query %q has unknown kind %q", q.Name, q.Kind)
+		}
+		if q.seeded && len(q.Params) > 0 {
+			return nil, fmt.Errorf("query %q can't use both seed values and params", q.Name)
+		}
+		if q.Expect != nil {
+			if err := q.Expect.validate(); err != nil {
+				return nil, fmt.Errorf("query %q: %w", q.Name, err)
+			}
+		}
+		q.generators = nil
+		for i, spec := range q.Params {
+			// Every parameter of every statement has its own sequence
+			generator, err := parseGenerator(spec, fmt.Sprintf("%s/%d", q.Name, i))
+			if err != nil {
+				return nil, fmt.Errorf("query %q param %d: %w", q.Name, i+1, err)
+			}
+			q.generators = append(q.generators, generator)
+		}
+		if q.Weight == 0 {
+			q.Weight = 1
+		}
+		w.total += q.Weight
+		w.queries = append(w.queries, q)
+	}
+	return w, nil
+}
+
+// Pick returns a statement chosen in proportion to its weight
+func (w *Workload) Pick(rng *rand.Rand) *WeightedQuery {
+	n := rng.Intn(w.total)
+	for i := range w.queries {
+		n -= w.queries[i].Weight
+		if n < 0 {
+			return &w.queries[i]
+		}
+	}
+	return &w.queries[len(w.queries)-1]
+}
+
+// Queries returns the statements in the mix
+func (w *Workload) Queries() []WeightedQuery {
+	return w.queries
+}
+
+// needsSeed reports whether any statement is bound to seed values
+func (w *Workload) needsSeed() bool {
+	for _, q := range w.queries {
+		if q.seeded {
+			return true
+		}
+	}
+	return false
+}
+
+// bindSeedColumns rewrites seeded statements written with :name placeholders to ? placeholders
+// bound to the indexes of the seed columns of the same name. Statements with ? placeholders take the seed columns in order.
+func (w *Workload) bindSeedColumns(columns []string) error {
+	indexes := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		indexes[column] = i
+	}
+	for i := range w.queries {
+		q := &w.queries[i]
+		if !q.seeded || q.columns != nil {
+			continue
+		}
+		sql, bound, positional, err := namedPlaceholders(q.SQL, indexes)
+		if err != nil {
+			return fmt.Errorf("query %q: %w", q.Name, err)
+		}
+		if positional || len(bound) == 0 {
+			continue
+		}
+		q.SQL = sql
+		q.columns = make([]int, len(bound))
+		for j, index := range bound {
+			q.columns[j] = index.(int)
+		}
+	}
+	return nil
+}
+
+// sqlQuoted matches quoted strings and identifiers and comments. sqlx.Named doesn't know SQL quoting,
+// so they are passed through as they are instead of having their colons taken for placeholders.
+var sqlQuoted = regexp.MustCompile(`'(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*"|` + "`(?:[^`]|``)*`" + `|#[^\n]*|-- [^\n]*|(?s:/\*.*?\*/)`)
+
+// namedPlaceholders rewrites the :name placeholders of a statement to ? ones with sqlx.Named, returning the values
+// of arg bound to them in order and whether the statement has ? placeholders.
+func namedPlaceholders(sql string, arg map[string]interface{}) (string, []interface{}, bool, error) {
+	var out strings.Builder
+	var bound []interface{}
+	positional := false
+	code := func(segment string) error {
+		positional = positional || strings.Contains(segment, "?")
+		rewritten, values, err := sqlx.Named(segment, arg)
+		if err != nil {
+			return err
+		}
+		out.WriteString(rewritten)
+		bound = append(bound, values...)
+		return nil
+	}
+
+	start := 0
+	for _, quoted := range sqlQuoted.FindAllStringIndex(sql, -1) {
+		if err := code(sql[start:quoted[0]]); err != nil {
+			return "", nil, false, err
+		}
+		out.WriteString(sql[quoted[0]:quoted[1]])
+		start = quoted[1]
+	}
+	if err := code(sql[start:]); err != nil {
+		return "", nil, false, err
+	}
+	return out.String(), bound, positional, nil
+}
+
+// buildWorkload collects the query template, queries list and query file into one mix,
+// falling back to the test query when nothing else is configured.
+// Scenarios with a transaction only run the transaction's statements.
+func buildWorkload(sc *ScenarioConfig) (*Workload, error) {
+	if sc.Transaction != nil {
+		return sc.Transaction.workload(sc)
+	}
+
+	var queries []WeightedQuery
+
+	// Statements in named scenarios are labeled with the scenario name
+	name := func(fallback string) string {
+		if sc.Name == "" {
+			return fallback
+		}
+		return sc.Name + "_" + fallback
+	}
+
+	if sc.QueryTemplate != "" {
+		templateName := "query_template"
+		if sc.Name != "" {
+			templateName = sc.Name
+		}
+		queries = append(queries, WeightedQuery{
+			Name:   templateName,
+			SQL:    sc.QueryTemplate,
+			Weight: sc.QueryTemplateWeight,
+			seeded: true,
+		})
+	}
+
+	for i, q := range sc.Queries {
+		if q.Name == "" {
+			q.Name = name(fmt.Sprintf("query_%d", i+1))
+		}
+		queries = append(queries, q)
+	}
+
+	if sc.QueryFile != "" {
+		statements, err := loadQueriesFromFile(sc.QueryFile)
+		if err != nil {
+			return nil, err
+		}
+		for i, statement := range statements {
+			q, err := parseQueryAnnotations(statement)
+			if err != nil {
+				return nil, fmt.Errorf("query file statement %d: %w", i+1, err)
+			}
+			if q.Name == "" {
+				q.Name = name(fmt.Sprintf("query_file_%d", i+1))
+			}
+			queries = append(queries, q)
+		}
+	}
+
+	if len(queries) == 0 && sc.TestQuery != "" {
+		queries = append(queries, WeightedQuery{Name: name("test_query"), SQL: sc.TestQuery})
+	}
+
+	return NewWorkload(queries)
+}
+
+// parseQueryAnnotations reads leading "-- name: x", "-- weight: n", "-- kind: read|write"
+// and "-- params: seq, int:1:100" comment lines from a statement
+func parseQueryAnnotations(statement string) (WeightedQuery, error) {
+	var q WeightedQuery
+	var body []string
+	for _, line := range strings.Split(statement, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(body) == 0 && strings.HasPrefix(trimmed, "--") {
+			key, value, found := strings.Cut(strings.TrimSpace(strings.TrimPrefix(trimmed, "--")), ":")
+			if found {
+				value = strings.TrimSpace(value)
+				switch strings.TrimSpace(key) {
+				case "name":
+					q.Name = value
+					continue
+				case "weight":
+					weight, err := strconv.Atoi(value)
+					if err != nil {
+						return q, fmt.Errorf("invalid weight %q: %w", value, err)
+					}
+					q.Weight = weight
+					continue
+				case "kind":
+					q.Kind = value
+					continue
+				case "params":
+					for _, spec := range strings.Split(value, ",") {
+						q.Params = append(q.Params, strings.TrimSpace(spec))
+					}
+					continue
+				}
+			}
+		}
+		body = append(body, line)
+	}
+	q.SQL = strings.TrimSpace(strings.Join(body, "\n"))
+	return q, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/exp/rand"
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewWorkload(t *testing.T) {
+	// An empty workload is rejected
+	if _, err := NewWorkload(nil); err == nil {
+		t.Fatalf("Expected error for empty workload")
+	}
+
+	// Negative weights are rejected
+	if _, err := NewWorkload([]WeightedQuery{{Name: "bad", SQL: "SELECT 1", Weight: -1}}); err == nil {
+		t.Fatalf("Expected error for negative weight")
+	}
+
+	// Unknown statement kinds are rejected
+	if _, err := NewWorkload([]WeightedQuery{{Name: "bad", SQL: "SELECT 1", Kind: "admin"}}); err == nil {
+		t.Fatalf("Expected error for unknown kind")
+	}
+
+	// Invalid generators are rejected
+	if _, err := NewWorkload([]WeightedQuery{{Name: "bad", SQL: "INSERT INTO t VALUES (?)", Params: []string{"int:9:1"}}}); err == nil {
+		t.Fatalf("Expected error for invalid param generator")
+	}
+
+	// Missing weights default to 1
+	workload, err := NewWorkload([]WeightedQuery{{Name: "a", SQL: "SELECT 1"}, {Name: "b", SQL: "SELECT 2", Weight: 3}})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	if workload.total != 4 {
+		t.Errorf("Expected total weight 4, got %d", workload.total)
+	}
+}
+
+func TestWorkloadPick(t *testing.T) {
+	workload, err := NewWorkload([]WeightedQuery{
+		{Name: "light", SQL: "SELECT 1", Weight: 1},
+		{Name: "heavy", SQL: "SELECT 2", Weight: 9},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+
+	// Pick many times and check the split roughly follows the weights
+	rng := rand.New(rand.NewSource(42))
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		counts[workload.Pick(rng).Name]++
+	}
+	if counts["heavy"] < 8500 || counts["heavy"] > 9500 {
+		t.Errorf("Expected about 9000 picks of heavy, got %d", counts["heavy"])
+	}
+	if counts["light"]+counts["heavy"] != 10000 {
+		t.Errorf("Unexpected picks: %v", counts)
+	}
+}
+
+func TestWeightedQueryUnmarshalYAML(t *testing.T) {
+	content := `
+queries:
+  - "SELECT 1"
+  - name: lookup
+    sql: "SELECT * FROM users WHERE id = 1"
+    weight: 5
+`
+	var dbCfg DatabaseConfig
+	if err := yaml.Unmarshal([]byte(content), &dbCfg); err != nil {
+		t.Fatalf("Failed to unmarshal queries: %v", err)
+	}
+	if len(dbCfg.Queries) != 2 {
+		t.Fatalf("Expected 2 queries, got %d", len(dbCfg.Queries))
+	}
+	if dbCfg.Queries[0].SQL != "SELECT 1" || dbCfg.Queries[0].Weight != 0 {
+		t.Errorf("Unexpected plain query: %+v", dbCfg.Queries[0])
+	}
+	if dbCfg.Queries[1].Name != "lookup" || dbCfg.Queries[1].Weight != 5 {
+		t.Errorf("Unexpected mapped query: %+v", dbCfg.Queries[1])
+	}
+}
+
+func TestBuildWorkload(t *testing.T) {
+	// Create a query file with an annotated statement
+	tmpFile, err := os.CreateTemp("", "queries.sql")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write([]byte("-- name: now\n-- weight: 2\n-- kind: write\nSELECT NOW();\nSELECT @@hostname;\n-- params: seq, string:4:8\nINSERT INTO users (id, name) VALUES (?, ?);\n")); err != nil {
+		t.Fatalf("Failed to write to temp file: %v", err)
+	}
+
+	workload, err := buildWorkload(&ScenarioConfig{
+		TestQuery:     "SELECT 1",
+		QueryFile:     tmpFile.Name(),
+		SeedQuery:     "SELECT id FROM users LIMIT 5",
+		QueryTemplate: "SELECT * FROM users WHERE id = ?",
+		Queries:       []WeightedQuery{{SQL: "SELECT 2"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+
+	expected := []WeightedQuery{
+		{Name: "query_template", SQL: "SELECT * FROM users WHERE id = ?", Weight: 1},
+		{Name: "query_1", SQL: "SELECT 2", Weight: 1},
+		{Name: "now", SQL: "SELECT NOW()", Weight: 2, Kind: kindWrite},
+		{Name: "query_file_2", SQL: "SELECT @@hostname", Weight: 1},
+		{Name: "query_file_3", SQL: "INSERT INTO users (id, name) VALUES (?, ?)", Weight: 1},
+	}
+	queries := workload.Queries()
+	if len(queries) != len(expected) {
+		t.Fatalf("Expected %d queries, got %d", len(expected), len(queries))
+	}
+	for i, q := range queries {
+		if q.Name != expected[i].Name || q.SQL != expected[i].SQL || q.Weight != expected[i].Weight || q.Kind != expected[i].Kind {
+			t.Errorf("Expected query %+v, got %+v", expected[i], q)
+		}
+	}
+	if !workload.needsSeed() {
+		t.Errorf("Expected workload with a query template to need seed values")
+	}
+	if values := queries[4].generate(rand.New(rand.NewSource(1))); len(values) != 2 {
+		t.Errorf("Expected 2 generated values for the annotated params, got %v", values)
+	}
+
+	// The test query is only used when nothing else is configured
+	workload, err = buildWorkload(&ScenarioConfig{TestQuery: "SELECT 1"})
+	if err != nil {
+		t.Fatalf("Failed to build fallback workload: %v", err)
+	}
+	if queries := workload.Queries(); len(queries) != 1 || queries[0].Name != "test_query" {
+		t.Errorf("Expected test_query fallback, got %+v", queries)
+	}
+}
+
+func TestBindSeedColumns(t *testing.T) {
+	workload, err := NewWorkload([]WeightedQuery{
+		{Name: "named", SQL: "SELECT * FROM orders WHERE user_id = :user_id AND status = :status", seeded: true},
+		{Name: "positional", SQL: "SELECT * FROM orders WHERE user_id = ?", seeded: true},
+		{Name: "unseeded", SQL: "SELECT :literal", Weight: 1},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	if err := workload.bindSeedColumns([]string{"status", "user_id"}); err != nil {
+		t.Fatalf("Failed to bind seed columns: %v", err)
+	}
+
+	queries := workload.Queries()
+	if queries[0].SQL != "SELECT * FROM orders WHERE user_id = ? AND status = ?" || !reflect.DeepEqual(queries[0].columns, []int{1, 0}) {
+		t.Errorf("Expected named placeholders to be rewritten, got %q bound to %v", queries[0].SQL, queries[0].columns)
+	}
+	if queries[1].columns != nil || queries[2].SQL != "SELECT :literal" {
+		t.Errorf("Expected positional and unseeded statements to be left alone, got %+v", queries[1:])
+	}
+
+	// Colons in literals, identifiers and comments aren't placeholders
+	workload, err = NewWorkload([]WeightedQuery{
+		{Name: "literal", SQL: "SELECT DATE_FORMAT(ts, '%H:%i'), `a:b` FROM orders WHERE user_id = :user_id AND note <> 'it''s :status' /* :id */", seeded: true},
+		{Name: "literal_only", SQL: "SELECT * FROM orders WHERE note = 'a:b' AND user_id = ?", seeded: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	if err := workload.bindSeedColumns([]string{"user_id"}); err != nil {
+		t.Fatalf("Failed to bind seed columns: %v", err)
+	}
+	queries = workload.Queries()
+	if queries[0].SQL != "SELECT DATE_FORMAT(ts, '%H:%i'), `a:b` FROM orders WHERE user_id = ? AND note <> 'it''s :status' /* :id */" || !reflect.DeepEqual(queries[0].columns, []int{0}) {
+		t.Errorf("Expected only the placeholder outside literals to be rewritten, got %q bound to %v", queries[0].SQL, queries[0].columns)
+	}
+	if queries[1].columns != nil || queries[1].SQL != "SELECT * FROM orders WHERE note = 'a:b' AND user_id = ?" {
+		t.Errorf("Expected a positional statement with a colon literal to be left alone, got %+v", queries[1])
+	}
+
+	// Every name needs a seed column
+	workload, err = NewWorkload([]WeightedQuery{{Name: "named", SQL: "SELECT * FROM orders WHERE user_id = :user_id", seeded: true}})
+	if err != nil {
+		t.Fatalf("Failed to build workload: %v", err)
+	}
+	if err := workload.bindSeedColumns([]string{"id"}); err == nil {
+		t.Errorf("Expected error for a placeholder without a seed column")
+	}
+}